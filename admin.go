@@ -0,0 +1,243 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Statuses of the transfers listed by the admin API
+const (
+	// StatusPending lists the transfers that are neither executed nor failed
+	StatusPending = "pending"
+	// StatusStuck lists the pending transfers whose last transaction is waiting to be mined
+	StatusStuck = "stuck"
+	// StatusFailed lists the transfers whose last transaction couldn't be sent or reverted
+	StatusFailed = "failed"
+)
+
+// Page sizes of the admin API
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// TransferView is a transfer as served by the admin API, with what the node knows of it
+// besides its record
+type TransferView struct {
+	*Transfer
+	// PendingSince is the block at which the last transaction of the sealer was first seen
+	// waiting to be mined, if it still is
+	PendingSince *uint64 `json:"pendingSince,omitempty"`
+	// Signatures are those of the withdrawal found on the side chain, for the transfers
+	// to the main chain
+	Signatures []Signature `json:"signatures,omitempty"`
+	// Vote is the last vote transaction of the sealer, for the transfers to the side chain
+	Vote *common.Hash `json:"vote,omitempty"`
+	// Votes are the owners of the side chain wallet and whether they voted, for the
+	// transfers to the side chain. They are read from the wallet, for a single transfer only.
+	Votes []OwnerVote `json:"votes,omitempty"`
+}
+
+// TransferPage is a page of the transfers listed by the admin API
+type TransferPage struct {
+	Transfers []TransferView `json:"transfers"`
+	Total     int            `json:"total"`
+	Offset    int            `json:"offset"`
+	Limit     int            `json:"limit"`
+}
+
+// GetTransferView returns the view of the transfer of sourceTx, or nil if the node has no record of it.
+// The votes of a deposit of the main chain are read from the side chain wallet sc.
+func GetTransferView(ctx context.Context, sc SideChainCaller, store Store, sourceTx common.Hash) (*TransferView, error) {
+	t, err := GetTransfer(store, sourceTx)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	view, err := transferView(store, t)
+	if err != nil || t.Direction != MainChainToSideChain {
+		return view, err
+	}
+	opts := &bind.CallOpts{Pending: false, Context: ctx}
+	owners, err := sc.GetOwners(opts)
+	if err != nil {
+		return nil, rpcError("get owners", err)
+	}
+	view.Votes, err = ownerVotes(opts, sc, owners, sourceTx)
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+func transferView(store Store, t *Transfer) (*TransferView, error) {
+	view := &TransferView{Transfer: t}
+	if t.DestTx != (common.Hash{}) && (t.State == TransferVoted || t.State == TransferSubmitted) {
+		seen, err := store.Get(pendingKey(t.DestTx))
+		if err != nil {
+			return nil, storeError("get pending transaction", err)
+		}
+		if seen != nil {
+			since, err := strconv.ParseUint(string(seen), 10, 64)
+			if err != nil {
+				return nil, storeError("get pending transaction", err)
+			}
+			view.PendingSince = &since
+		}
+	}
+	if t.Direction == SideChainToMainChain {
+		sigs, err := GetSignatures(store, t.SourceTx)
+		if err != nil {
+			return nil, err
+		}
+		view.Signatures = sigs
+	} else {
+		for _, step := range t.Steps {
+			if step.State == TransferVoted && step.TxHash != (common.Hash{}) {
+				vote := step.TxHash
+				view.Vote = &vote
+			}
+		}
+	}
+	return view, nil
+}
+
+// ListTransfers returns limit transfers with status from offset, or with any of the
+// statuses if status is empty, the oldest first
+func ListTransfers(store Store, status string, offset int, limit int) (*TransferPage, error) {
+	var views []TransferView
+	err := ForEachTransfer(store, func(t *Transfer) error {
+		if t.State == TransferExecuted || t.State == TransferRemoved {
+			return nil
+		}
+		if status == StatusFailed && t.State != TransferFailed || status == StatusPending && t.State == TransferFailed {
+			return nil
+		}
+		view, err := transferView(store, t)
+		if err != nil {
+			return err
+		}
+		if status == StatusStuck && view.PendingSince == nil {
+			return nil
+		}
+		views = append(views, *view)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Steps[0].Time.Before(views[j].Steps[0].Time)
+	})
+
+	page := &TransferPage{Transfers: []TransferView{}, Total: len(views), Offset: offset, Limit: limit}
+	if offset < len(views) {
+		end := offset + limit
+		if end > len(views) {
+			end = len(views)
+		}
+		page.Transfers = views[offset:end]
+	}
+	return page, nil
+}
+
+// AdminHandler serves the read-only admin API on the records of store:
+// GET /transfers/<source tx hash> returns a transfer, with the votes read from sc, and
+// GET /transfers?status=pending|stuck|failed&offset=0&limit=50 lists them.
+func AdminHandler(store Store, sc SideChainCaller) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/transfers", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "only GET is supported")
+			return
+		}
+		query := req.URL.Query()
+		status := query.Get("status")
+		switch status {
+		case "", StatusPending, StatusStuck, StatusFailed:
+		default:
+			writeJSONError(w, http.StatusBadRequest, "status must be pending, stuck or failed")
+			return
+		}
+		offset, err := queryInt(query.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "offset must be a positive number")
+			return
+		}
+		limit, err := queryInt(query.Get("limit"), defaultPageSize)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+			return
+		}
+		page, err := ListTransfers(store, status, offset, limit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	})
+	mux.HandleFunc("/transfers/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "only GET is supported")
+			return
+		}
+		hash := strings.TrimPrefix(req.URL.Path, "/transfers/")
+		if len(strings.TrimPrefix(hash, "0x")) != 2*common.HashLength {
+			writeJSONError(w, http.StatusBadRequest, hash+" is not a transaction hash")
+			return
+		}
+		view, err := GetTransferView(req.Context(), sc, store, common.HexToHash(hash))
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if view == nil {
+			writeJSONError(w, http.StatusNotFound, "no transfer for "+hash)
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	})
+	return mux
+}
+
+func queryInt(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
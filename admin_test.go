@@ -0,0 +1,179 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// adminOwners are the owners of the side chain wallet of the admin tests
+var adminOwners = []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02")}
+
+func hashOf(n int64) common.Hash {
+	return common.BigToHash(big.NewInt(n))
+}
+
+// seedTransfers records transfers 1 to 5, each in a different state, observed one minute apart
+func seedTransfers(store Store) {
+	start := time.Now().Add(-time.Hour)
+	states := []TransferState{TransferExecuted, TransferVoted, TransferSubmitted, TransferFailed, TransferMined}
+	for i, state := range states {
+		n := int64(i + 1)
+		PutTransfer(store, &Transfer{
+			Direction: SideChainToMainChain,
+			SourceTx:  hashOf(n),
+			State:     state,
+			DestTx:    hashOf(100 + n),
+			Steps:     []TransferStep{{State: TransferObserved, Time: start.Add(time.Duration(n) * time.Minute)}, {State: state}},
+		})
+	}
+	// The withdrawal of transfer 3 is waiting to be mined
+	store.Put(pendingKey(hashOf(103)), []byte("42"))
+	indexSignature(store, &sidechain.SideChainSignatureAdded{
+		TxHash: hashOf(3),
+		V:      27,
+		Raw:    types.Log{BlockNumber: 7, TxHash: common.HexToHash("0x77")},
+	})
+}
+
+func TestListTransfers(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		seedTransfers(store)
+		tests := []struct {
+			name   string
+			status string
+			offset int
+			limit  int
+			want   []common.Hash
+			total  int
+		}{
+			{"All but the executed ones, oldest first", "", 0, 10, []common.Hash{hashOf(2), hashOf(3), hashOf(4), hashOf(5)}, 4},
+			{"Pending", StatusPending, 0, 10, []common.Hash{hashOf(2), hashOf(3), hashOf(5)}, 3},
+			{"Stuck", StatusStuck, 0, 10, []common.Hash{hashOf(3)}, 1},
+			{"Failed", StatusFailed, 0, 10, []common.Hash{hashOf(4)}, 1},
+			{"Paged", "", 1, 2, []common.Hash{hashOf(3), hashOf(4)}, 4},
+			{"Past the last page", "", 10, 2, []common.Hash{}, 4},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := ListTransfers(store, tt.status, tt.offset, tt.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				have := []common.Hash{}
+				for _, view := range page.Transfers {
+					have = append(have, view.SourceTx)
+				}
+				if !reflect.DeepEqual(have, tt.want) || page.Total != tt.total {
+					t.Errorf("have = %v (%v), want %v (%v)", have, page.Total, tt.want, tt.total)
+				}
+			})
+		}
+	})
+}
+
+func TestGetTransferView(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		seedTransfers(store)
+		sc := &traceSideChain{owners: adminOwners, confirmed: map[common.Address]bool{adminOwners[1]: true}}
+		view, err := GetTransferView(context.Background(), sc, store, hashOf(3))
+		if err != nil || view == nil {
+			t.Fatalf("have = %v, %v, want a transfer", view, err)
+		}
+		if view.PendingSince == nil || *view.PendingSince != 42 {
+			t.Errorf("have = %v, want %v", view.PendingSince, 42)
+		}
+		if len(view.Signatures) != 1 || view.Signatures[0].Block != 7 {
+			t.Errorf("have = %+v, want the indexed signature", view.Signatures)
+		}
+		if view.Vote != nil || view.Votes != nil {
+			t.Errorf("have = %v, %v, want no votes", view.Vote, view.Votes)
+		}
+
+		// A deposit on the main chain comes with the votes of the owners
+		deposit := &Transfer{Direction: MainChainToSideChain, SourceTx: hashOf(6), State: TransferVoted, DestTx: hashOf(106),
+			Steps: []TransferStep{{State: TransferObserved}, {State: TransferConfirmed}, {State: TransferVoted, TxHash: hashOf(106)}}}
+		PutTransfer(store, deposit)
+		view, err = GetTransferView(context.Background(), sc, store, hashOf(6))
+		if err != nil || view == nil || view.Vote == nil || *view.Vote != hashOf(106) {
+			t.Fatalf("have = %+v, %v, want the vote %v", view, err, hashOf(106).Hex())
+		}
+		want := []OwnerVote{{Owner: adminOwners[0], Voted: false}, {Owner: adminOwners[1], Voted: true}}
+		if !reflect.DeepEqual(view.Votes, want) {
+			t.Errorf("have = %v, want %v", view.Votes, want)
+		}
+		if view, err := GetTransferView(context.Background(), sc, store, hashOf(42)); view != nil || err != nil {
+			t.Errorf("have = %v, %v, want %v, %v", view, err, nil, nil)
+		}
+	})
+}
+
+func TestAdminHandler(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		seedTransfers(store)
+		handler := AdminHandler(store, &traceSideChain{owners: adminOwners})
+		tests := []struct {
+			name   string
+			method string
+			path   string
+			want   int
+		}{
+			{"Transfer", "GET", "/transfers/" + hashOf(2).Hex(), http.StatusOK},
+			{"Unknown transfer", "GET", "/transfers/" + hashOf(42).Hex(), http.StatusNotFound},
+			{"Invalid hash", "GET", "/transfers/0x42", http.StatusBadRequest},
+			{"List", "GET", "/transfers?status=stuck&limit=10", http.StatusOK},
+			{"Invalid status", "GET", "/transfers?status=lost", http.StatusBadRequest},
+			{"Invalid limit", "GET", "/transfers?limit=1000", http.StatusBadRequest},
+			{"Read only", "POST", "/transfers", http.StatusMethodNotAllowed},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+				if w.Code != tt.want {
+					t.Errorf("have = %v, want %v: %s", w.Code, tt.want, w.Body)
+				}
+			})
+		}
+
+		t.Run("Transfers are served as JSON", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/transfers/"+hashOf(3).Hex(), nil))
+			var view TransferView
+			if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil {
+				t.Fatal(err)
+			}
+			if view.Transfer == nil || view.SourceTx != hashOf(3) || view.State != TransferSubmitted {
+				t.Errorf("have = %+v, want transfer %v", view, hashOf(3).Hex())
+			}
+		})
+	})
+}
@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"math/big"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// MainChainTransactor sends the transactions of the node to the main chain wallet
+type MainChainTransactor interface {
+	SubmitTransaction(opts *bind.TransactOpts, txHash [32]byte, destination common.Address, value *big.Int,
+		data []byte, v []uint8, r [][32]byte, s [][32]byte) (*types.Transaction, error)
+}
+
+// MainChainWatcher subscribes to the new events of the main chain wallet
+type MainChainWatcher interface {
+	WatchDeposit(opts *bind.WatchOpts, sink chan<- *mainchain.MainChainDeposit,
+		sender []common.Address, to []common.Address) (event.Subscription, error)
+}
+
+// MainChainEvents reads the past events of the main chain wallet. The iterators returned by
+// the bindings can't be made outside of them, so the events are returned all at once.
+type MainChainEvents interface {
+	Deposits(opts *bind.FilterOpts) ([]*mainchain.MainChainDeposit, error)
+	Executions(opts *bind.FilterOpts, txHash [][32]byte) ([]*mainchain.MainChainExecution, error)
+}
+
+// MainChainBackend is the main chain wallet, as used by the node
+type MainChainBackend interface {
+	MainChainTransactor
+	MainChainWatcher
+	MainChainEvents
+}
+
+// SideChainCaller reads the state of the side chain wallet
+type SideChainCaller interface {
+	Required(opts *bind.CallOpts) (uint8, error)
+	IsOwner(opts *bind.CallOpts, arg0 common.Address) (bool, error)
+	GetOwners(opts *bind.CallOpts) ([]common.Address, error)
+	IsConfirmed(opts *bind.CallOpts, txHash [32]byte) (bool, error)
+	Confirmations(opts *bind.CallOpts, arg0 [32]byte, arg1 common.Address) (bool, error)
+	GetTransactionMC(opts *bind.CallOpts, txHash [32]byte) (struct {
+		Destination common.Address
+		Value       *big.Int
+		Data        []byte
+		V           []uint8
+		R           [][32]byte
+		S           [][32]byte
+	}, error)
+}
+
+// SideChainTransactor sends the transactions of the node to the side chain wallet
+type SideChainTransactor interface {
+	SubmitTransactionSC(opts *bind.TransactOpts, txHash [32]byte, destination common.Address,
+		value *big.Int, data []byte) (*types.Transaction, error)
+	SubmitSignatureMC(opts *bind.TransactOpts, txHash [32]byte, destination common.Address,
+		value *big.Int, data []byte, v uint8, r [32]byte, s [32]byte) (*types.Transaction, error)
+}
+
+// SideChainWatcher subscribes to the new events of the side chain wallet
+type SideChainWatcher interface {
+	WatchDeposit(opts *bind.WatchOpts, sink chan<- *sidechain.SideChainDeposit,
+		sender []common.Address, to []common.Address) (event.Subscription, error)
+	WatchSignatureAdded(opts *bind.WatchOpts, sink chan<- *sidechain.SideChainSignatureAdded) (event.Subscription, error)
+}
+
+// SideChainEvents reads the past events of the side chain wallet, see MainChainEvents
+type SideChainEvents interface {
+	Deposits(opts *bind.FilterOpts) ([]*sidechain.SideChainDeposit, error)
+	SignaturesAdded(opts *bind.FilterOpts) ([]*sidechain.SideChainSignatureAdded, error)
+	Executions(opts *bind.FilterOpts, txHash [][32]byte) ([]*sidechain.SideChainExecution, error)
+}
+
+// SideChainBackend is the side chain wallet, as used by the node
+type SideChainBackend interface {
+	SideChainCaller
+	SideChainTransactor
+	SideChainWatcher
+	SideChainEvents
+}
+
+// MainChainBinding is the MainChainBackend of a generated binding
+type MainChainBinding struct {
+	*mainchain.MainChain
+}
+
+// Deposits returns the Deposit events in the blocks of opts
+func (b MainChainBinding) Deposits(opts *bind.FilterOpts) ([]*mainchain.MainChainDeposit, error) {
+	i, err := b.FilterDeposit(opts, []common.Address{}, []common.Address{})
+	if err != nil {
+		return nil, err
+	}
+	defer i.Close()
+	var events []*mainchain.MainChainDeposit
+	for i.Next() {
+		events = append(events, i.Event)
+	}
+	return events, i.Error()
+}
+
+// Executions returns the Execution events of txHash in the blocks of opts
+func (b MainChainBinding) Executions(opts *bind.FilterOpts, txHash [][32]byte) ([]*mainchain.MainChainExecution, error) {
+	i, err := b.FilterExecution(opts, txHash)
+	if err != nil {
+		return nil, err
+	}
+	defer i.Close()
+	var events []*mainchain.MainChainExecution
+	for i.Next() {
+		events = append(events, i.Event)
+	}
+	return events, i.Error()
+}
+
+// SideChainBinding is the SideChainBackend of a generated binding
+type SideChainBinding struct {
+	*sidechain.SideChain
+}
+
+// Deposits returns the Deposit events in the blocks of opts
+func (b SideChainBinding) Deposits(opts *bind.FilterOpts) ([]*sidechain.SideChainDeposit, error) {
+	i, err := b.FilterDeposit(opts, []common.Address{}, []common.Address{})
+	if err != nil {
+		return nil, err
+	}
+	defer i.Close()
+	var events []*sidechain.SideChainDeposit
+	for i.Next() {
+		events = append(events, i.Event)
+	}
+	return events, i.Error()
+}
+
+// SignaturesAdded returns the SignatureAdded events in the blocks of opts
+func (b SideChainBinding) SignaturesAdded(opts *bind.FilterOpts) ([]*sidechain.SideChainSignatureAdded, error) {
+	i, err := b.FilterSignatureAdded(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer i.Close()
+	var events []*sidechain.SideChainSignatureAdded
+	for i.Next() {
+		events = append(events, i.Event)
+	}
+	return events, i.Error()
+}
+
+// Executions returns the Execution events of txHash in the blocks of opts
+func (b SideChainBinding) Executions(opts *bind.FilterOpts, txHash [][32]byte) ([]*sidechain.SideChainExecution, error) {
+	i, err := b.FilterExecution(opts, txHash)
+	if err != nil {
+		return nil, err
+	}
+	defer i.Close()
+	var events []*sidechain.SideChainExecution
+	for i.Next() {
+		events = append(events, i.Event)
+	}
+	return events, i.Error()
+}
+
+var (
+	_ MainChainBackend = MainChainBinding{}
+	_ SideChainBackend = SideChainBinding{}
+)
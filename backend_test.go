@@ -0,0 +1,242 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeMainChain returns canned events. The other methods are not implemented and panic.
+type fakeMainChain struct {
+	MainChainBackend
+	deposits   []*mainchain.MainChainDeposit
+	executions []*mainchain.MainChainExecution
+	err        error
+}
+
+func (f *fakeMainChain) Deposits(opts *bind.FilterOpts) ([]*mainchain.MainChainDeposit, error) {
+	return f.deposits, f.err
+}
+
+// Executions returns the executions of the blocks asked. Like the hosted nodes, it refuses
+// the queries without an end block.
+func (f *fakeMainChain) Executions(opts *bind.FilterOpts, txHash [][32]byte) ([]*mainchain.MainChainExecution, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if opts.End == nil {
+		return nil, errors.New("query returned more than 10000 results")
+	}
+	var events []*mainchain.MainChainExecution
+	for _, event := range f.executions {
+		if event.Raw.BlockNumber >= opts.Start && event.Raw.BlockNumber <= *opts.End {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// fakeSideChain returns the SignatureAdded events of the blocks asked, in the order of the list
+type fakeSideChain struct {
+	SideChainBackend
+	signatures []*sidechain.SideChainSignatureAdded
+}
+
+func (f *fakeSideChain) SignaturesAdded(opts *bind.FilterOpts) ([]*sidechain.SideChainSignatureAdded, error) {
+	var events []*sidechain.SideChainSignatureAdded
+	for _, event := range f.signatures {
+		if event.Raw.BlockNumber >= opts.Start && (opts.End == nil || event.Raw.BlockNumber <= *opts.End) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func TestExecutedMC(t *testing.T) {
+	executed := common.HexToHash("0x1")
+	chain := &fakeChain{headers: map[uint64]*types.Header{}}
+	for n := int64(1); n <= 10; n++ {
+		chain.headers[uint64(n)] = &types.Header{Number: big.NewInt(n)}
+	}
+	mc := &fakeMainChain{executions: []*mainchain.MainChainExecution{
+		{TxHash: executed, Raw: types.Log{BlockNumber: 7, TxHash: common.HexToHash("0x7")}},
+	}}
+	scanner := &LogScanner{Window: 4}
+
+	testStores(t, func(t *testing.T, store Store) {
+		tests := []struct {
+			name    string
+			mc      *fakeMainChain
+			txHash  common.Hash
+			want    bool
+			wantErr error
+		}{
+			{
+				name:    "Errors of the node are RPC errors",
+				mc:      &fakeMainChain{err: errors.New("connection refused")},
+				txHash:  executed,
+				want:    false,
+				wantErr: ErrRPC,
+			},
+			{
+				name:   "Executed if there is an Execution event",
+				mc:     mc,
+				txHash: executed,
+				want:   true,
+			},
+			{
+				name:   "Not executed without Execution events",
+				mc:     mc,
+				txHash: common.HexToHash("0x2"),
+				want:   false,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				have, err := ExecutedMC(context.Background(), chain, tt.mc, store, scanner, tt.txHash)
+				if have != tt.want {
+					t.Errorf("have = %v, want %v", have, tt.want)
+				}
+				if (tt.wantErr == nil && err != nil) || (tt.wantErr != nil && !IsKind(err, tt.wantErr)) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+
+		t.Run("The index is read up to the head", func(t *testing.T) {
+			if have, _ := GetLastProcessedBlock(store, executionIndexBlock); have != 10 {
+				t.Errorf("have = %v, want %v", have, 10)
+			}
+		})
+
+		t.Run("Executions dropped by a reorg are removed", func(t *testing.T) {
+			if err := rollbackExecutions(store, 6); err != nil {
+				t.Fatal(err)
+			}
+			e, _ := GetExecution(store, executed)
+			last, _ := GetLastProcessedBlock(store, executionIndexBlock)
+			if e != nil || last != 6 {
+				t.Errorf("have = %v, %v, want %v, %v", e, last, nil, 6)
+			}
+		})
+	})
+}
+
+func TestProcessMCDepositsFilterError(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		PersistLastBlock(store, "MCDeposit", 5)
+		mc := &fakeMainChain{err: errors.New("connection refused")}
+		end := uint64(10)
+
+		err := ProcessMCDeposits(context.Background(), nil, mc, nil, store, nil, 6, &end)
+		if !IsKind(err, ErrRPC) {
+			t.Errorf("err = %v, want %v", err, ErrRPC)
+		}
+		if have, _ := GetLastProcessedBlock(store, "MCDeposit"); have != 5 {
+			t.Errorf("have = %v, want %v", have, 5)
+		}
+	})
+}
+
+// retrylessStore can't queue retries
+type retrylessStore struct {
+	Store
+}
+
+func (s retrylessStore) Update(fn func(tx StoreTx) error) error {
+	return s.Store.Update(func(tx StoreTx) error {
+		return fn(retrylessTx{tx})
+	})
+}
+
+type retrylessTx struct {
+	StoreTx
+}
+
+func (tx retrylessTx) Put(key string, value []byte) error {
+	if strings.HasPrefix(key, "retry/") {
+		return errors.New("no space left on device")
+	}
+	return tx.StoreTx.Put(key, value)
+}
+
+// unreachableSideChain fails every call
+type unreachableSideChain struct {
+	SideChainBackend
+}
+
+func (f unreachableSideChain) IsConfirmed(opts *bind.CallOpts, txHash [32]byte) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestProcessMCDepositsRetryError(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		PersistLastBlock(store, "MCDeposit", 5)
+		mc := &fakeMainChain{deposits: []*mainchain.MainChainDeposit{
+			{Value: big.NewInt(1), Raw: types.Log{BlockNumber: 7, TxHash: common.HexToHash("0x7")}},
+		}}
+		end := uint64(10)
+
+		// The deposit can't be voted nor queued for a retry, it is read again next time
+		err := ProcessMCDeposits(context.Background(), &Sender{Auth: &bind.TransactOpts{}}, mc, unreachableSideChain{},
+			retrylessStore{store}, nil, 6, &end)
+		if !IsKind(err, ErrStore) {
+			t.Errorf("err = %v, want %v", err, ErrStore)
+		}
+		if have, _ := GetLastProcessedBlock(store, "MCDeposit"); have != 5 {
+			t.Errorf("have = %v, want %v", have, 5)
+		}
+	})
+}
+
+func TestIndexSignaturesUnordered(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		withdrawal := common.HexToHash("0x1")
+		event := func(block uint64) *sidechain.SideChainSignatureAdded {
+			return &sidechain.SideChainSignatureAdded{
+				TxHash: withdrawal,
+				Raw:    types.Log{BlockNumber: block, TxHash: common.BigToHash(new(big.Int).SetUint64(block))},
+			}
+		}
+		sc := &fakeSideChain{signatures: []*sidechain.SideChainSignatureAdded{event(9), event(3), event(7)}}
+		end := uint64(10)
+
+		err := IndexSignatures(context.Background(), sc, store, &LogScanner{Window: 4, Workers: 2}, &end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sigs, _ := GetSignatures(store, withdrawal); len(sigs) != 3 {
+			t.Errorf("have = %v signatures, want %v", len(sigs), 3)
+		}
+		if have, _ := GetLastProcessedBlock(store, signatureIndexBlock); have != end {
+			t.Errorf("have = %v, want %v", have, end)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Utility to inspect the submissions that failed too many times and send them again
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	icn "github.com/WeTrustPlatform/poa-interchain-node"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jessevdk/go-flags"
+)
+
+var opts struct {
+	icn.ConfigOptions
+	icn.StoreOptions
+	Redrive []string `short:"r" long:"redrive" description:"Hash of a source transaction whose dead letters go back to the retry queue. Can be repeated"`
+}
+
+func main() {
+	_, err := icn.ParseConfig(flags.NewParser(&opts, flags.Default), os.Args[1:])
+	if err != nil {
+		if _, ok := err.(*flags.Error); !ok {
+			fmt.Println(err)
+		}
+		os.Exit(0)
+	}
+
+	store, err := icn.OpenStore(opts.Store, opts.DBPath)
+	if err != nil {
+		log.Fatalf("Couldn't open the store: %v", err)
+	}
+	defer store.Close()
+
+	// Send the given transfers back to the retry queue
+	if len(opts.Redrive) > 0 {
+		for _, hash := range opts.Redrive {
+			if err := icn.Redrive(store, common.HexToHash(hash)); err != nil {
+				log.Printf("Redrive error: %v", err)
+				continue
+			}
+			log.Printf("%s will be retried by the node", hash)
+		}
+		return
+	}
+
+	// List the dead letters
+	entries, err := icn.DeadLetters(store)
+	if err != nil {
+		log.Fatalf("Couldn't read the dead letters: %v", err)
+	}
+	for _, e := range entries {
+		fmt.Printf("%s %-10s to %s value %s, %d attempts, last at %s: %s\n",
+			e.SourceTx.Hex(), e.Kind, e.To.Hex(), e.Value, e.Attempts,
+			e.LastAttempt.Format("2006-01-02 15:04:05"), e.LastError)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"strings"
 	"bufio"
 
+	icn "github.com/WeTrustPlatform/poa-interchain-node"
 	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
 	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -39,16 +40,19 @@ import (
 var opts struct {
 	MainChain   bool   `short:"m" long:"mainchain" description:"Deploy the main chain wallet"`
 	SideChain   bool   `short:"s" long:"sidechain" description:"Deploy the side chain wallet"`
-	KeyJSONPath string `short:"k" long:"keyjson" required:"true" description:"Path to the key json file of the transactor"`
-	Password    string `short:"p" long:"password" description:"Password needed to unlock the keyjson"`
-	Addresses   string `short:"a" long:"addresses" required:"true" description:"Comma separated list of the owners"`
+	icn.ConfigOptions
+	icn.KeyOptions
+	Addresses   string `short:"a" long:"addresses" required:"true" validate:"addresses" description:"Comma separated list of the owners"`
 	Required 		int64  `long:"required" default:"2" description:"Number of votes required for a transaction, must be inferior or equal to the number of owners"`
-	RPC         string `long:"rpc" default:"http://127.0.0.1:8545" description:"Address of the node RPC endpoint, can be HTTP or IPC"`
+	RPC         string `long:"rpc" default:"http://127.0.0.1:8545" validate:"endpoint" description:"Address of the node RPC endpoint, can be HTTP or IPC"`
 }
 
 func main() {
-	_, err := flags.Parse(&opts)
+	_, err := icn.ParseConfig(flags.NewParser(&opts, flags.Default), os.Args[1:])
 	if err != nil {
+		if _, ok := err.(*flags.Error); !ok {
+			fmt.Println(err)
+		}
 		os.Exit(0)
 	}
 
@@ -29,6 +29,7 @@ import (
 	"strings"
 	"bufio"
 
+	icn "github.com/WeTrustPlatform/poa-interchain-node"
 	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
 	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -41,11 +42,11 @@ import (
 var opts struct {
 	MainChain   bool   `short:"m" long:"mainchain" description:"Target the main chain wallet"`
 	SideChain   bool   `short:"s" long:"sidechain" description:"Target the side chain wallet"`
-	KeyJSONPath string `short:"k" long:"keyjson" required:"true" description:"Path to the JSON private key file of the sealer"`
-	Password    string `short:"p" long:"password"  description:"Passphrase needed to unlock the sealer's JSON key"`
-	Endpoint    string `short:"e" long:"endpoint" required:"true" description:"URL or path of the origin chain endpoint"`
-	Wallet      string `short:"w" long:"wallet" required:"true"  description:"Ethereum address of the multisig wallet on the origin chain"`
-	Receiver    string `short:"r" long:"receiver" required:"true" description:"Ethereum address of the receiver on the target chain"`
+	icn.ConfigOptions
+	icn.KeyOptions
+	Endpoint    string `short:"e" long:"endpoint" required:"true" validate:"endpoint" description:"URL or path of the origin chain endpoint"`
+	Wallet      string `short:"w" long:"wallet" required:"true" validate:"address" description:"Ethereum address of the multisig wallet on the origin chain"`
+	Receiver    string `short:"r" long:"receiver" required:"true" validate:"address" description:"Ethereum address of the receiver on the target chain"`
 	Value       string `short:"v" long:"value" required:"true" description:"Value (wei) to transfer to the receiver"`
 }
 
@@ -54,8 +55,11 @@ type depositable interface {
 }
 
 func main() {
-	_, err := flags.Parse(&opts)
+	_, err := icn.ParseConfig(flags.NewParser(&opts, flags.Default), os.Args[1:])
 	if err != nil {
+		if _, ok := err.(*flags.Error); !ok {
+			fmt.Println(err)
+		}
 		os.Exit(0)
 	}
 
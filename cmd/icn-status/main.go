@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Utility to follow a transfer across both chains from the hash of its deposit, without a running node
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	icn "github.com/WeTrustPlatform/poa-interchain-node"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/jessevdk/go-flags"
+)
+
+var opts struct {
+	icn.ConfigOptions
+	MainChainEndpoint string        `long:"mainchainendpoint" required:"true" validate:"endpoint" description:"URL or path of the main chain endpoint"`
+	SideChainEndpoint string        `long:"sidechainendpoint" required:"true" validate:"endpoint" description:"URL or path of the side chain endpoint"`
+	MainChainWallet   string        `long:"mainchainwallet" required:"true" validate:"address" description:"Ethereum address of the multisig wallet on the main chain"`
+	SideChainWallet   string        `long:"sidechainwallet" required:"true" validate:"address" description:"Ethereum address of the multisig wallet on the side chain"`
+	ScanWindow        uint64        `long:"scanwindow" default:"5000" description:"Number of blocks read at once when looking for the signatures and the execution of a transfer. 0 to read them at once"`
+	Timeout           time.Duration `long:"timeout" default:"60s" description:"How long the chains may take to answer"`
+	JSON              bool          `long:"json" description:"Print the transfer as JSON"`
+	Args              struct {
+		TxHash string `positional-arg-name:"txhash" description:"Hash of the deposit transaction"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// exitUsage is returned when the flags or the arguments are invalid, like icn does
+const exitUsage = 2
+
+func main() {
+	_, err := icn.ParseConfig(flags.NewParser(&opts, flags.Default), os.Args[1:])
+	if err != nil {
+		flagsErr, ok := err.(*flags.Error)
+		if ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		if !ok {
+			fmt.Println(err)
+		}
+		os.Exit(exitUsage)
+	}
+	if len(strings.TrimPrefix(opts.Args.TxHash, "0x")) != 2*common.HashLength {
+		log.Printf("Invalid transaction hash: %s", opts.Args.TxHash)
+		os.Exit(exitUsage)
+	}
+	txHash := common.HexToHash(opts.Args.TxHash)
+
+	// Connect to both chains
+	mainChainClient, err := ethclient.Dial(opts.MainChainEndpoint)
+	if err != nil {
+		log.Fatalf("Couldn't connect to the main chain: %v", err)
+	}
+	sideChainClient, err := ethclient.Dial(opts.SideChainEndpoint)
+	if err != nil {
+		log.Fatalf("Couldn't connect to the side chain: %v", err)
+	}
+
+	// Attach both wallets
+	sideChainWalletAddress := common.HexToAddress(opts.SideChainWallet)
+	mcBinding, err := mainchain.NewMainChain(common.HexToAddress(opts.MainChainWallet), mainChainClient)
+	if err != nil {
+		log.Fatalf("Couldn't instanciate the main chain contract: %v", err)
+	}
+	scBinding, err := sidechain.NewSideChain(sideChainWalletAddress, sideChainClient)
+	if err != nil {
+		log.Fatalf("Couldn't instanciate the side chain contract: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	trace, err := icn.TraceTransfer(ctx, mainChainClient, sideChainClient,
+		icn.MainChainBinding{MainChain: mcBinding}, icn.SideChainBinding{SideChain: scBinding},
+		sideChainWalletAddress, &icn.LogScanner{Window: opts.ScanWindow}, txHash)
+	if err != nil {
+		log.Fatalf("Couldn't trace the transfer: %v", err)
+	}
+	if trace == nil {
+		log.Fatalf("%s was not found on either chain", txHash.Hex())
+	}
+
+	if opts.JSON {
+		out, _ := json.MarshalIndent(trace, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	printTrace(trace)
+}
+
+// printTrace prints the steps of a transfer, in the order they happen
+func printTrace(t *icn.TransferTrace) {
+	source, destination, vote := "main chain", "side chain", "confirmed"
+	if t.Direction == icn.SideChainToMainChain {
+		source, destination, vote = "side chain", "main chain", "signed"
+	}
+	fmt.Printf("Deposit   %s on the %s, block %d\n", t.SourceTx.Hex(), source, t.SourceBlock)
+	fmt.Printf("          from %s to %s, value %s\n", t.Sender.Hex(), t.To.Hex(), t.Value)
+
+	votes := 0
+	for _, o := range t.Owners {
+		if o.Voted {
+			votes++
+		}
+	}
+	fmt.Printf("Votes     %d of the %d required\n", votes, t.Required)
+	for _, o := range t.Owners {
+		state := "not " + vote
+		if o.Voted {
+			state = vote
+		}
+		fmt.Printf("          %s %s\n", o.Owner.Hex(), state)
+	}
+	for _, sig := range t.Signatures {
+		fmt.Printf("Signature %s, block %d\n", sig.TxHash.Hex(), sig.Block)
+	}
+
+	switch {
+	case !t.Executed:
+		fmt.Printf("Execution not executed on the %s yet\n", destination)
+	case t.ExecutionTx == common.Hash{}:
+		fmt.Printf("Execution executed on the %s\n", destination)
+	default:
+		fmt.Printf("Execution %s on the %s, block %d\n", t.ExecutionTx.Hex(), destination, t.ExecutionBlock)
+	}
+}
@@ -24,47 +24,168 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	icn "github.com/WeTrustPlatform/poa-interchain-node"
-	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
 	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/jessevdk/go-flags"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var opts struct {
-	MainChain         bool   `short:"m" long:"mainchain" required:"false" description:"Watch the main chain only"`
-	SideChain         bool   `short:"s" long:"sidechain" required:"false" description:"Watch the side chain only"`
-	KeyJSONPath       string `short:"k" long:"keyjson" required:"true" description:"Path to the JSON private key file of the sealer"`
-	Password          string `short:"p" long:"password" required:"false" description:"Passphrase needed to unlock the sealer's JSON key"`
-	MainChainEndpoint string `long:"mainchainendpoint" required:"true" description:"URL or path of the main chain endpoint"`
-	SideChainEndpoint string `long:"sidechainendpoint" required:"true" description:"URL or path of the side chain endpoint"`
-	MainChainWallet   string `long:"mainchainwallet" required:"true" description:"Ethereum address of the multisig wallet on the main chain"`
-	SideChainWallet   string `long:"sidechainwallet" required:"true" description:"Ethereum address of the multisig wallet on the side chain"`
-	DBPath            string `short:"d" long:"dbpath" required:"true" description:"Where to save and get last proccessed blocks"`
-	NBlocks           uint64 `short:"n" long:"nblocks" required:"false" description:"Number of blocks to process. If not specified the program will process until the last block"`
+	icn.ConfigOptions
+	icn.KeyOptions
+	icn.StoreOptions
+
+	MainChain              bool          `short:"m" long:"mainchain" required:"false" description:"Watch the main chain only"`
+	SideChain              bool          `short:"s" long:"sidechain" required:"false" description:"Watch the side chain only"`
+	MainChainEndpoint      string        `long:"mainchainendpoint" required:"true" validate:"endpoint" description:"URL or path of the main chain endpoint"`
+	SideChainEndpoint      string        `long:"sidechainendpoint" required:"true" validate:"endpoint" description:"URL or path of the side chain endpoint"`
+	MainChainWallet        string        `long:"mainchainwallet" required:"true" validate:"address" description:"Ethereum address of the multisig wallet on the main chain"`
+	SideChainWallet        string        `long:"sidechainwallet" required:"true" validate:"address" description:"Ethereum address of the multisig wallet on the side chain"`
+	NBlocks                uint64        `short:"n" long:"nblocks" required:"false" description:"Number of blocks to process. If not specified the program will process until the last block"`
+	Watch                  bool          `short:"w" long:"watch" required:"false" description:"Keep running and relay new events as they arrive, until interrupted"`
+	PollInterval           time.Duration `long:"pollinterval" default:"15s" description:"How often to check for new blocks in watch mode when the endpoint doesn't support subscriptions"`
+	MainChainConfirmations uint64        `long:"mainchainconfirmations" default:"0" description:"Number of blocks to wait on the main chain before relaying a deposit"`
+	SideChainConfirmations uint64        `long:"sidechainconfirmations" default:"0" description:"Number of blocks to wait on the side chain before relaying a deposit or a signature"`
+	Retries                int           `long:"retries" default:"5" description:"Number of times a failed submission is sent before it goes to the dead letters"`
+	RetryDelay             time.Duration `long:"retrydelay" default:"1m" description:"How long to wait before sending a failed submission again, doubled after each attempt"`
+	MaxRetryDelay          time.Duration `long:"maxretrydelay" default:"1h" description:"Longest wait between two attempts of a failed submission"`
+	MainChainGas           string        `long:"mainchaingas" default:"suggested" choice:"suggested" choice:"fixed" choice:"zero" description:"How to price the gas of the transactions sent to the main chain"`
+	MainChainGasPrice      uint64        `long:"mainchaingasprice" description:"Gas price (wei) on the main chain in the fixed mode"`
+	MainChainGasMultiplier float64       `long:"mainchaingasmultiplier" default:"1" description:"Multiplier applied to the gas price suggested by the main chain"`
+	MainChainGasCap        uint64        `long:"mainchaingascap" description:"Highest gas price (wei) paid on the main chain. No limit if not specified"`
+	SideChainGas           string        `long:"sidechaingas" default:"suggested" choice:"suggested" choice:"fixed" choice:"zero" description:"How to price the gas of the transactions sent to the side chain"`
+	SideChainGasPrice      uint64        `long:"sidechaingasprice" description:"Gas price (wei) on the side chain in the fixed mode"`
+	SideChainGasMultiplier float64       `long:"sidechaingasmultiplier" default:"1" description:"Multiplier applied to the gas price suggested by the side chain"`
+	SideChainGasCap        uint64        `long:"sidechaingascap" description:"Highest gas price (wei) paid on the side chain. No limit if not specified"`
+	StuckBlocks            uint64        `long:"stuckblocks" default:"20" description:"Number of blocks after which a pending transaction is sent again with a higher gas price. 0 to never replace them"`
+	FallbackTimeout        time.Duration `long:"fallbacktimeout" default:"5m" description:"How long to wait for each sealer before this one in the rotation to execute a withdrawal. 0 to always submit withdrawals"`
+	ScanWindow             uint64        `long:"scanwindow" default:"5000" description:"Number of blocks read at once when scanning past events, halved when the endpoint refuses a query. 0 to read each range at once"`
+	ScanWorkers            int           `long:"scanworkers" default:"4" description:"Number of windows of past events fetched at the same time. They are still processed in block order"`
+	ShutdownTimeout        time.Duration `long:"shutdowntimeout" default:"30s" description:"How long the transactions being sent when the node is interrupted may take to be sent and recorded"`
+	HTTPAddr               string        `long:"httpaddr" description:"Address on which to serve the metrics of the node at /metrics, its health at /healthz and /readyz, and its transfers at /transfers, such as :9100. Not served if not specified"`
+	MaxLag                 uint64        `long:"maxlag" default:"100" description:"Number of blocks, on top of the confirmations, the events may be processed behind the head of their chain before /readyz fails. 0 for no limit"`
+	MainChainMinBalance    uint64        `long:"mainchainminbalance" description:"Balance (wei) of the sealer on the main chain under which /readyz fails"`
+	SideChainMinBalance    uint64        `long:"sidechainminbalance" description:"Balance (wei) of the sealer on the side chain under which /readyz fails"`
+	LogLevel               string        `long:"loglevel" default:"info" choice:"debug" choice:"info" choice:"warn" choice:"error" description:"Least severe level of the entries written to the log"`
+	LogFormat              string        `long:"logformat" default:"logfmt" choice:"logfmt" choice:"json" description:"Format of the entries of the log"`
+	LogFile                string        `long:"logfile" description:"File the log is appended to. Standard error if not specified"`
+}
+
+// Exit codes
+const (
+	// exitOK is returned when the node finished its work, or shut down cleanly when interrupted
+	exitOK = 0
+	// exitError is returned when the node couldn't start, or stopped on an error
+	exitError = 1
+	// exitUsage is returned when the flags are invalid
+	exitUsage = 2
+	// exitForced is returned when the node was interrupted a second time, or didn't shut
+	// down in time. The state of the node may not have been saved.
+	exitForced = 3
+)
+
+// shutdownMargin is how long the node may take to record its state after the transactions
+// in flight are sent
+const shutdownMargin = 5 * time.Second
+
+// gasPolicy returns the gas policy of the flags of a chain. A price of 0 is no price, which
+// the fixed mode refuses.
+func gasPolicy(mode string, price uint64, multiplier float64, cap uint64) icn.GasPolicy {
+	policy := icn.GasPolicy{Mode: mode, Multiplier: multiplier}
+	if price != 0 {
+		policy.Price = new(big.Int).SetUint64(price)
+	}
+	if cap != 0 {
+		policy.Cap = new(big.Int).SetUint64(cap)
+	}
+	return policy
+}
+
+// minBalance returns the minimum balance of a flag, nil if there is none
+func minBalance(wei uint64) *big.Int {
+	if wei == 0 {
+		return nil
+	}
+	return new(big.Int).SetUint64(wei)
 }
 
-func handleError(err error) {
+// healthTimeout is how long the checks of /healthz and /readyz may take
+const healthTimeout = 5 * time.Second
+
+// openLog returns the logger of the node, writing to path, or to the standard error if path
+// is empty. The file is closed by close.
+func openLog(path string, level string, format string) (logger *icn.Logger, close func() error, err error) {
+	l, err := icn.ParseLevel(level)
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(0)
+		return nil, nil, err
+	}
+	if path == "" {
+		return icn.NewLogger(os.Stderr, l, format), func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
 	}
+	return icn.NewLogger(f, l, format), f.Close, nil
+}
+
+// serveHTTP serves the metrics, the health and the admin API of the node on addr, in the background
+func serveHTTP(addr string, logger *icn.Logger, relayer *icn.Relayer, store icn.Store, sc icn.SideChainCaller) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", icn.HealthHandler(relayer.Health, healthTimeout))
+	mux.Handle("/readyz", icn.HealthHandler(relayer.Ready, healthTimeout))
+	admin := icn.AdminHandler(store, sc)
+	mux.Handle("/transfers", admin)
+	mux.Handle("/transfers/", admin)
+	go func() {
+		logger.With(icn.Fields{"component": "http"}).WithError(http.Serve(listener, mux)).Error("stopped serving")
+	}()
+	return nil
 }
 
 func main() {
-	_, err := flags.Parse(&opts)
+	os.Exit(run())
+}
+
+func run() int {
+	_, err := icn.ParseConfig(flags.NewParser(&opts, flags.Default), os.Args[1:])
 	if err != nil {
-		os.Exit(0)
+		flagsErr, ok := err.(*flags.Error)
+		if ok && flagsErr.Type == flags.ErrHelp {
+			return exitOK
+		}
+		if !ok {
+			fmt.Println(err.Error())
+		}
+		return exitUsage
 	}
 
+	logger, closeLog, err := openLog(opts.LogFile, opts.LogLevel, opts.LogFormat)
+	if err != nil {
+		fmt.Println(err.Error())
+		return exitError
+	}
+	defer closeLog()
+	icn.SetLogger(logger)
+	shutdownLogger := logger.With(icn.Fields{"component": "shutdown"})
+
 	// Prompt passphrase if not passed as a flag
 	if opts.Password == "" {
 		reader := bufio.NewReader(os.Stdin)
@@ -81,51 +202,124 @@ func main() {
 
 	// Connect to both chains
 	mainChainClient, err := ethclient.Dial(opts.MainChainEndpoint)
-	handleError(err)
+	if err != nil {
+		logger.WithError(err).Error("can't connect to the main chain")
+		return exitError
+	}
 	sideChainClient, err := ethclient.Dial(opts.SideChainEndpoint)
-	handleError(err)
+	if err != nil {
+		logger.WithError(err).Error("can't connect to the side chain")
+		return exitError
+	}
 
 	sideChainWalletAddress := common.HexToAddress(opts.SideChainWallet)
 	mainChainWalletAddress := common.HexToAddress(opts.MainChainWallet)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	// Run until the work is done, or in watch mode until interrupted. A long catch-up is
+	// stopped with a signal rather than a deadline, so that nothing is recorded as failed.
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
 	// Open the account key file
 	keyJSON, err := ioutil.ReadFile(opts.KeyJSONPath)
-	handleError(err)
+	if err != nil {
+		logger.WithError(err).Error("can't read the key file")
+		return exitError
+	}
 
-	// Create a transactor
+	// Decrypt the key of the sealer
 	key, err := keystore.DecryptKey(keyJSON, opts.Password)
-	handleError(err)
-	auth := bind.NewKeyedTransactor(key.PrivateKey)
-
-	// Attach the wallet
-	sc, err := sidechain.NewSideChain(sideChainWalletAddress, sideChainClient)
-	handleError(err)
-	mc, err := mainchain.NewMainChain(mainChainWalletAddress, mainChainClient)
-	handleError(err)
+	if err != nil {
+		logger.WithError(err).Error("can't decrypt the key")
+		return exitError
+	}
 
-	var wg sync.WaitGroup
+	// Open the state store
+	store, err := icn.OpenStore(opts.Store, opts.DBPath)
+	if err != nil {
+		logger.WithError(err).Error("can't open the store")
+		return exitError
+	}
 
-	// Watch the main chain
-	if opts.MainChain {
-		wg.Add(1)
-		start := icn.GetLastProcessedBlock(opts.DBPath, "MCDeposit")
-		go icn.ProcessMCDeposits(ctx, auth, mc, sc,
-			opts.DBPath, start, icn.EndBlock(start, opts.NBlocks), &wg)
+	options := []icn.Option{
+		icn.WithChains(opts.MainChain, opts.SideChain),
+		icn.WithBlocks(opts.NBlocks),
+		icn.WithConfirmations(opts.MainChainConfirmations, opts.SideChainConfirmations),
+		icn.WithGas(
+			gasPolicy(opts.MainChainGas, opts.MainChainGasPrice, opts.MainChainGasMultiplier, opts.MainChainGasCap),
+			gasPolicy(opts.SideChainGas, opts.SideChainGasPrice, opts.SideChainGasMultiplier, opts.SideChainGasCap),
+		),
+		icn.WithRetryPolicy(icn.RetryPolicy{
+			MaxAttempts:  opts.Retries,
+			InitialDelay: opts.RetryDelay,
+			MaxDelay:     opts.MaxRetryDelay,
+		}),
+		icn.WithStuckBlocks(opts.StuckBlocks),
+		icn.WithFallbackTimeout(opts.FallbackTimeout),
+		icn.WithShutdownTimeout(opts.ShutdownTimeout),
+		icn.WithScanner(&icn.LogScanner{Window: opts.ScanWindow, Workers: opts.ScanWorkers}),
+		icn.WithHealth(opts.MaxLag, minBalance(opts.MainChainMinBalance), minBalance(opts.SideChainMinBalance)),
+	}
+	if opts.Watch {
+		options = append(options, icn.WithWatch(opts.PollInterval))
+	}
+	relayer, err := icn.NewRelayer(icn.Config{
+		MainChainClient: mainChainClient,
+		SideChainClient: sideChainClient,
+		MainChainWallet: mainChainWalletAddress,
+		SideChainWallet: sideChainWalletAddress,
+		Key:             key.PrivateKey,
+		Store:           store,
+	}, options...)
+	if err == nil && opts.HTTPAddr != "" {
+		var scBinding *sidechain.SideChain
+		scBinding, err = sidechain.NewSideChain(sideChainWalletAddress, sideChainClient)
+		if err == nil {
+			err = serveHTTP(opts.HTTPAddr, logger, relayer, store, icn.SideChainBinding{SideChain: scBinding})
+		}
+	}
+	if err == nil {
+		err = relayer.Start(ctx)
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't start the relayer")
+		store.Close()
+		return exitError
 	}
 
-	// Watch the side chain
-	if opts.SideChain {
-		wg.Add(2)
-		dstart := icn.GetLastProcessedBlock(opts.DBPath, "SCDeposit")
-		sstart := icn.GetLastProcessedBlock(opts.DBPath, "SCSignatureAdded")
-		go icn.ProcessSCDeposits(ctx, auth, mc, sc, sideChainWalletAddress, key.PrivateKey,
-			opts.DBPath, dstart, icn.EndBlock(dstart, opts.NBlocks), &wg)
-		go icn.ProcessSCSignatureAdded(ctx, auth, mc, sc,
-			opts.DBPath, sstart, icn.EndBlock(sstart, opts.NBlocks), &wg)
+	done := make(chan error, 1)
+	go func() {
+		done <- relayer.Wait()
+	}()
+	select {
+	case err = <-done:
+	case sig := <-sigs:
+		// Let the transactions in flight be sent and recorded, unless interrupted again
+		shutdownLogger.With(icn.Fields{"signal": sig}).Info("finishing the transactions in flight")
+		cancel()
+		timeout := time.NewTimer(opts.ShutdownTimeout + shutdownMargin)
+		defer timeout.Stop()
+		select {
+		case err = <-done:
+		case sig := <-sigs:
+			shutdownLogger.With(icn.Fields{"signal": sig}).Warn("interrupted again, exiting now")
+			return exitForced
+		case <-timeout.C:
+			shutdownLogger.With(icn.Fields{"timeout": (opts.ShutdownTimeout + shutdownMargin).String()}).Error("timed out, exiting now")
+			return exitForced
+		}
 	}
 
-	wg.Wait()
+	// Flush the state of the node
+	if closeErr := store.Close(); closeErr != nil {
+		shutdownLogger.WithError(closeErr).Error("can't close the store")
+		return exitError
+	}
+	if err != nil {
+		logger.WithError(err).Error("stopped on an error")
+		return exitError
+	}
+	return exitOK
 }
@@ -0,0 +1,253 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigOptions select the config file of a command, and the profile to use in it
+type ConfigOptions struct {
+	ConfigPath string `long:"config" env:"ICN_CONFIG" description:"Path to a TOML or YAML file holding the options of the command, named like the long flags"`
+	Profile    string `long:"profile" env:"ICN_PROFILE" description:"Profile of the config file applied on top of its other options, such as dev, testnet or prod"`
+}
+
+// KeyOptions are the options of the commands that send transactions
+type KeyOptions struct {
+	KeyJSONPath string `short:"k" long:"keyjson" required:"true" description:"Path to the JSON private key file of the account sending the transactions"`
+	Password    string `short:"p" long:"password" description:"Passphrase needed to unlock the JSON key. Prompted for if not given"`
+}
+
+// StoreOptions locate the state of a node
+type StoreOptions struct {
+	DBPath string `short:"d" long:"dbpath" required:"true" description:"Where the node saves its state"`
+	Store  string `long:"store" default:"file" choice:"file" choice:"bolt" description:"How the node saves its state: one file per key, or an embedded bolt database"`
+}
+
+// envPrefix is the prefix of the environment variables holding options
+const envPrefix = "ICN_"
+
+// ParseConfig parses args into the options of parser, like parser.ParseArgs, on top of the
+// options read from the config file and from the environment. The file given by --config
+// holds options named like their long flags, and a table of profiles; the one selected by
+// --profile overrides the options of the file. The ICN_<LONG FLAG> environment variables
+// override the file, and args override both. The options tagged validate:"address",
+// validate:"addresses" or validate:"endpoint" are then checked.
+func ParseConfig(parser *flags.Parser, args []string) ([]string, error) {
+	var selected ConfigOptions
+	if _, err := flags.NewParser(&selected, flags.IgnoreUnknown|flags.PrintErrors).ParseArgs(args); err != nil {
+		return nil, err
+	}
+
+	settings := map[string][]string{}
+	if selected.ConfigPath != "" {
+		var err error
+		settings, err = readConfig(selected.ConfigPath, selected.Profile)
+		if err != nil {
+			return nil, err
+		}
+	} else if selected.Profile != "" {
+		return nil, fmt.Errorf("profile %s: no config file given", selected.Profile)
+	}
+
+	var prefix []string
+	for _, option := range parserOptions(parser) {
+		name := option.LongName
+		if name == "" || name == "config" || name == "profile" {
+			continue
+		}
+		if value, ok := os.LookupEnv(envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))); ok {
+			settings[name] = []string{value}
+		}
+		for _, value := range settings[name] {
+			if option.Field().Type.Kind() != reflect.Bool {
+				prefix = append(prefix, "--"+name+"="+value)
+				continue
+			}
+			set, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not a boolean", name, value)
+			}
+			if set {
+				prefix = append(prefix, "--"+name)
+			}
+		}
+	}
+
+	rest, err := parser.ParseArgs(append(prefix, args...))
+	if err != nil {
+		return rest, err
+	}
+	return rest, validateOptions(parser)
+}
+
+// readConfig returns the options of a TOML or YAML config file, with those of profile
+// on top of the others
+func readConfig(path string, profile string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		_, err = toml.Decode(string(data), &doc)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("%s: config files are .toml, .yaml or .yml files", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	profiles, _ := stringMap(doc["profiles"])
+	delete(doc, "profiles")
+	settings := map[string][]string{}
+	if err := addSettings(settings, doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if profile == "" {
+		return settings, nil
+	}
+	options, ok := stringMap(profiles[profile])
+	if !ok {
+		return nil, fmt.Errorf("%s: no profile %s", path, profile)
+	}
+	if err := addSettings(settings, options); err != nil {
+		return nil, fmt.Errorf("%s: profile %s: %v", path, profile, err)
+	}
+	return settings, nil
+}
+
+// addSettings adds the options of a table of a config file to settings. A list gives
+// several values to an option that can be repeated.
+func addSettings(settings map[string][]string, options map[string]interface{}) error {
+	for name, value := range options {
+		switch value := value.(type) {
+		case []interface{}:
+			settings[name] = nil
+			for _, item := range value {
+				settings[name] = append(settings[name], fmt.Sprint(item))
+			}
+		case map[string]interface{}, map[interface{}]interface{}:
+			return fmt.Errorf("%s is a table, not an option", name)
+		default:
+			settings[name] = []string{fmt.Sprint(value)}
+		}
+	}
+	return nil
+}
+
+// stringMap returns a table of a config file with string keys. YAML tables have keys of any type.
+func stringMap(table interface{}) (map[string]interface{}, bool) {
+	switch table := table.(type) {
+	case map[string]interface{}:
+		return table, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(table))
+		for k, v := range table {
+			m[fmt.Sprint(k)] = v
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// parserOptions returns the options of parser and of its groups, sorted by long name
+func parserOptions(parser *flags.Parser) []*flags.Option {
+	var options []*flags.Option
+	var walk func(groups []*flags.Group)
+	walk = func(groups []*flags.Group) {
+		for _, group := range groups {
+			options = append(options, group.Options()...)
+			walk(group.Groups())
+		}
+	}
+	walk(parser.Groups())
+	sort.Slice(options, func(i, j int) bool { return options[i].LongName < options[j].LongName })
+	return options
+}
+
+// validateOptions checks the values of the options tagged with validate
+func validateOptions(parser *flags.Parser) error {
+	for _, option := range parserOptions(parser) {
+		value, _ := option.Value().(string)
+		if value == "" {
+			continue
+		}
+		var err error
+		switch option.Field().Tag.Get("validate") {
+		case "address":
+			err = validateAddress(value)
+		case "addresses":
+			for _, address := range strings.Split(value, ",") {
+				if err = validateAddress(strings.TrimSpace(address)); err != nil {
+					break
+				}
+			}
+		case "endpoint":
+			err = validateEndpoint(value)
+		}
+		if err != nil {
+			return fmt.Errorf("--%s: %v", option.LongName, err)
+		}
+	}
+	return nil
+}
+
+func validateAddress(value string) error {
+	if !common.IsHexAddress(value) {
+		return fmt.Errorf("%q is not an Ethereum address", value)
+	}
+	return nil
+}
+
+// validateEndpoint accepts the URLs of HTTP and websocket endpoints, and the paths of IPC endpoints
+func validateEndpoint(value string) error {
+	if !strings.Contains(value, "://") {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+		if u.Host == "" {
+			return fmt.Errorf("%q has no host", value)
+		}
+		return nil
+	}
+	return fmt.Errorf("%q is not an http, https, ws or wss URL, or the path of an IPC endpoint", value)
+}
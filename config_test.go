@@ -0,0 +1,160 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type testOptions struct {
+	ConfigOptions
+	StoreOptions
+	Endpoint string        `long:"endpoint" required:"true" validate:"endpoint"`
+	Wallet   string        `long:"wallet" validate:"address"`
+	Owners   string        `long:"owners" validate:"addresses"`
+	Watch    bool          `long:"watch"`
+	Interval time.Duration `long:"interval" default:"15s"`
+}
+
+func TestParseConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "icn")
+	defer os.RemoveAll(dir)
+	tomlPath := filepath.Join(dir, "icn.toml")
+	ioutil.WriteFile(tomlPath, []byte(`
+dbpath = "sealer1db"
+endpoint = "http://127.0.0.1:8545"
+interval = "1m"
+
+[profiles.prod]
+endpoint = "wss://mainnet.example.org"
+wallet = "0x0000000000000000000000000000000000000042"
+watch = true
+`), 0644)
+	yamlPath := filepath.Join(dir, "icn.yaml")
+	ioutil.WriteFile(yamlPath, []byte(`
+dbpath: sealer2db
+endpoint: sidechain/geth.ipc
+profiles:
+  dev:
+    store: bolt
+`), 0644)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want testOptions
+	}{
+		{
+			name: "Options come from the file",
+			args: []string{"--config", tomlPath},
+			want: testOptions{Endpoint: "http://127.0.0.1:8545", Interval: time.Minute},
+		},
+		{
+			name: "The profile overrides the file",
+			args: []string{"--config", tomlPath, "--profile", "prod"},
+			want: testOptions{Endpoint: "wss://mainnet.example.org", Wallet: "0x0000000000000000000000000000000000000042", Watch: true, Interval: time.Minute},
+		},
+		{
+			name: "The environment overrides the file",
+			args: []string{"--config", tomlPath},
+			env:  map[string]string{"ICN_ENDPOINT": "ws://127.0.0.1:8546", "ICN_WATCH": "true"},
+			want: testOptions{Endpoint: "ws://127.0.0.1:8546", Watch: true, Interval: time.Minute},
+		},
+		{
+			name: "Flags override the environment and the file",
+			args: []string{"--config", tomlPath, "--interval", "5s", "--endpoint=http://10.0.0.1:8545"},
+			env:  map[string]string{"ICN_ENDPOINT": "ws://127.0.0.1:8546"},
+			want: testOptions{Endpoint: "http://10.0.0.1:8545", Interval: 5 * time.Second},
+		},
+		{
+			name: "The config file and profile can come from the environment",
+			env:  map[string]string{"ICN_CONFIG": yamlPath, "ICN_PROFILE": "dev"},
+			want: testOptions{Endpoint: "sidechain/geth.ipc", Interval: 15 * time.Second},
+		},
+		{
+			name: "Works without a config file",
+			args: []string{"-d", "db", "--endpoint", "node.ipc"},
+			want: testOptions{Endpoint: "node.ipc", Interval: 15 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			var opts testOptions
+			if _, err := ParseConfig(flags.NewParser(&opts, flags.None), tt.args); err != nil {
+				t.Fatal(err)
+			}
+			have := testOptions{Endpoint: opts.Endpoint, Wallet: opts.Wallet, Watch: opts.Watch, Interval: opts.Interval}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("have = %+v, want %+v", have, tt.want)
+			}
+		})
+	}
+
+	t.Run("The profile picks the store", func(t *testing.T) {
+		var opts testOptions
+		ParseConfig(flags.NewParser(&opts, flags.None), []string{"--config", yamlPath, "--profile", "dev"})
+		if opts.DBPath != "sealer2db" || opts.Store != "bolt" {
+			t.Errorf("have = %v, %v, want %v, %v", opts.DBPath, opts.Store, "sealer2db", "bolt")
+		}
+	})
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "icn")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "icn.toml")
+	ioutil.WriteFile(path, []byte("dbpath = \"db\"\nendpoint = \"node.ipc\"\n"), 0644)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"Missing required option", []string{"-d", "db"}},
+		{"Unknown profile", []string{"--config", path, "--profile", "prod"}},
+		{"Profile without a config file", []string{"--profile", "prod", "-d", "db", "--endpoint", "node.ipc"}},
+		{"Missing config file", []string{"--config", filepath.Join(dir, "missing.toml")}},
+		{"Unsupported config file", []string{"--config", filepath.Join(dir, "icn.json")}},
+		{"Invalid choice", []string{"--config", path, "--store", "sql"}},
+		{"Invalid address", []string{"--config", path, "--wallet", "0x42"}},
+		{"Invalid address in a list", []string{"--config", path, "--owners", "0x0000000000000000000000000000000000000042,sealer"}},
+		{"Invalid endpoint scheme", []string{"--config", path, "--endpoint", "ftp://127.0.0.1"}},
+		{"Endpoint without a host", []string{"--config", path, "--endpoint", "http://"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts testOptions
+			if _, err := ParseConfig(flags.NewParser(&opts, flags.None), tt.args); err == nil {
+				t.Errorf("have = %v, want an error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// wholeBlock is the log index of a cursor that handled all the logs of its block
+const wholeBlock = ^uint(0)
+
+// Cursor is the position of the last event log handled in a stream of events,
+// whether or not it led to a transaction
+type Cursor struct {
+	BlockNumber uint64
+	BlockHash   common.Hash
+	LogIndex    uint
+}
+
+// Handled tells if the log comes at or before the cursor in its stream.
+// Nothing was handled by a nil cursor.
+func (c *Cursor) Handled(l types.Log) bool {
+	if c == nil {
+		return false
+	}
+	return l.BlockNumber < c.BlockNumber || l.BlockNumber == c.BlockNumber && l.Index <= c.LogIndex
+}
+
+// Start returns the first block that may hold logs the cursor didn't handle yet
+func (c *Cursor) Start() uint64 {
+	if c.LogIndex == wholeBlock {
+		return c.BlockNumber + 1
+	}
+	return c.BlockNumber
+}
+
+// GetCursor returns the cursor of eventType, or nil if none was saved yet
+func GetCursor(store Store, eventType string) (*Cursor, error) {
+	cursor, err := getCursor(store, eventType)
+	return cursor, storeError("get cursor", err)
+}
+
+func getCursor(s storeReadWriter, eventType string) (*Cursor, error) {
+	c, err := s.Get(eventType + ".cursor")
+	if err != nil || len(c) == 0 {
+		return nil, err
+	}
+	var cursor Cursor
+	var blockHash string
+	if _, err := fmt.Sscanf(string(c), "%d %s %d", &cursor.BlockNumber, &blockHash, &cursor.LogIndex); err != nil {
+		return nil, err
+	}
+	cursor.BlockHash = common.HexToHash(blockHash)
+	return &cursor, nil
+}
+
+func putCursor(s storeReadWriter, eventType string, cursor Cursor) error {
+	return s.Put(eventType+".cursor", []byte(fmt.Sprintf("%d %s %d", cursor.BlockNumber, cursor.BlockHash.Hex(), cursor.LogIndex)))
+}
+
+// advanceCursor moves the cursor of eventType past all the logs of blockNumber,
+// unless it is already further
+func advanceCursor(s storeReadWriter, eventType string, blockNumber uint64) error {
+	cursor, err := getCursor(s, eventType)
+	if err != nil {
+		return err
+	}
+	if cursor != nil && cursor.Start() > blockNumber {
+		return nil
+	}
+	return putCursor(s, eventType, Cursor{BlockNumber: blockNumber, LogIndex: wholeBlock})
+}
+
+// ResumeBlock returns the block to process eventType from. The stores saved by the previous
+// versions have no cursor, they start again at the last processed block.
+func ResumeBlock(store Store, eventType string) (uint64, error) {
+	cursor, err := GetCursor(store, eventType)
+	if err != nil {
+		return 0, err
+	}
+	if cursor == nil {
+		return GetLastProcessedBlock(store, eventType)
+	}
+	return cursor.Start(), nil
+}
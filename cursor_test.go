@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestCursorHandled(t *testing.T) {
+	cursor := &Cursor{BlockNumber: 5, LogIndex: 2}
+	tests := []struct {
+		name   string
+		cursor *Cursor
+		log    types.Log
+		want   bool
+	}{
+		{"Handled the logs of earlier blocks", cursor, types.Log{BlockNumber: 4, Index: 7}, true},
+		{"Handled the earlier logs of its block", cursor, types.Log{BlockNumber: 5, Index: 1}, true},
+		{"Handled its own log", cursor, types.Log{BlockNumber: 5, Index: 2}, true},
+		{"Didn't handle the later logs of its block", cursor, types.Log{BlockNumber: 5, Index: 3}, false},
+		{"Didn't handle the logs of later blocks", cursor, types.Log{BlockNumber: 6, Index: 0}, false},
+		{"Handled the whole block", &Cursor{BlockNumber: 5, LogIndex: wholeBlock}, types.Log{BlockNumber: 5, Index: 9}, true},
+		{"Handled nothing without a cursor", nil, types.Log{BlockNumber: 0, Index: 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if have := tt.cursor.Handled(tt.log); have != tt.want {
+				t.Errorf("have = %v, want %v", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestResumeBlock(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		t.Run("Starts at the last processed block without a cursor", func(t *testing.T) {
+			store.Put("MCDeposit", []byte("42"))
+			if have, _ := ResumeBlock(store, "MCDeposit"); have != 42 {
+				t.Errorf("have = %d, want %d", have, 42)
+			}
+		})
+
+		t.Run("Starts at the block of the last event", func(t *testing.T) {
+			cp := Checkpoint{BlockNumber: 43, BlockHash: common.HexToHash("0x1"), TxHash: common.HexToHash("0x2"), LogIndex: 3}
+			if err := PersistCheckpoint(store, "MCDeposit", cp); err != nil {
+				t.Fatal(err)
+			}
+			cursor, _ := GetCursor(store, "MCDeposit")
+			if cursor == nil || *cursor != cp.cursor() {
+				t.Errorf("have = %v, want %v", cursor, cp.cursor())
+			}
+			if have, _ := ResumeBlock(store, "MCDeposit"); have != 43 {
+				t.Errorf("have = %d, want %d", have, 43)
+			}
+		})
+
+		t.Run("Starts after a window read to the end", func(t *testing.T) {
+			var to uint64 = 50
+			if err := checkpointWindow(store, "MCDeposit", &to); err != nil {
+				t.Fatal(err)
+			}
+			if have, _ := ResumeBlock(store, "MCDeposit"); have != 51 {
+				t.Errorf("have = %d, want %d", have, 51)
+			}
+		})
+
+		t.Run("Never moves back to an earlier window", func(t *testing.T) {
+			var to uint64 = 45
+			if err := checkpointWindow(store, "MCDeposit", &to); err != nil {
+				t.Fatal(err)
+			}
+			if have, _ := ResumeBlock(store, "MCDeposit"); have != 51 {
+				t.Errorf("have = %d, want %d", have, 51)
+			}
+		})
+	})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"errors"
+	"strings"
+)
+
+// Kinds of the errors returned by the package, see IsKind
+var (
+	// ErrRPC means a call to a chain endpoint failed. It can be retried.
+	ErrRPC = errors.New("rpc error")
+	// ErrStore means the state of the node couldn't be read or saved
+	ErrStore = errors.New("store error")
+	// ErrRevert means a transaction was reverted, or would be
+	ErrRevert = errors.New("transaction reverted")
+)
+
+// revertErrors are parts of the errors returned by nodes for transactions that revert
+var revertErrors = []string{
+	"always failing transaction",
+	"execution reverted",
+	"invalid opcode",
+	"transaction reverted",
+}
+
+// Error wraps the cause of an error with its kind and what the node was doing
+type Error struct {
+	// Kind is ErrRPC, ErrStore or ErrRevert
+	Kind error
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Cause returns the error that caused e
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// IsKind tells if err is of the given kind, ErrRPC, ErrStore or ErrRevert
+func IsKind(err error, kind error) bool {
+	if err == kind {
+		return true
+	}
+	e, ok := err.(*Error)
+	return ok && (e.Kind == kind || e.Err == kind)
+}
+
+// wrapError wraps err with its kind, unless it is nil or already wrapped
+func wrapError(kind error, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+func rpcError(op string, err error) error {
+	return wrapError(ErrRPC, op, err)
+}
+
+func storeError(op string, err error) error {
+	return wrapError(ErrStore, op, err)
+}
+
+// txError wraps the error of a transaction sent to a chain: ErrRevert if the node
+// refused it because it reverts, ErrRPC otherwise
+func txError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range revertErrors {
+		if strings.Contains(msg, s) {
+			return wrapError(ErrRevert, op, err)
+		}
+	}
+	return rpcError(op, err)
+}
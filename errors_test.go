@@ -0,0 +1,89 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestErrorKinds(t *testing.T) {
+	cause := errors.New("connection refused")
+	tests := []struct {
+		name string
+		err  error
+		kind error
+		want bool
+	}{
+		{"Wraps RPC errors", rpcError("get head", cause), ErrRPC, true},
+		{"Wraps store errors", storeError("get transfer", cause), ErrStore, true},
+		{"Tells kinds apart", rpcError("get head", cause), ErrStore, false},
+		{"Keeps the kind of a wrapped error", storeError("read transfers", rpcError("get head", cause)), ErrRPC, true},
+		{"Recognizes reverted transactions", txError("send transaction", errors.New("gas required exceeds allowance or always failing transaction")), ErrRevert, true},
+		{"Sends the other transaction errors as RPC errors", txError("send transaction", errors.New("nonce too low")), ErrRPC, true},
+		{"Matches the kinds themselves", ErrRevert, ErrRevert, true},
+		{"Matches the reverted receipts", &Error{Kind: ErrRPC, Op: "check", Err: ErrRevert}, ErrRevert, true},
+		{"Doesn't match other errors", cause, ErrRPC, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if have := IsKind(tt.err, tt.kind); have != tt.want {
+				t.Errorf("have = %v, want %v", have, tt.want)
+			}
+		})
+	}
+
+	t.Run("Keeps nil errors nil", func(t *testing.T) {
+		if err := rpcError("get head", nil); err != nil {
+			t.Errorf("have = %v, want %v", err, nil)
+		}
+	})
+
+	t.Run("Describes what failed", func(t *testing.T) {
+		if have, want := rpcError("get head", cause).Error(), "get head: connection refused"; have != want {
+			t.Errorf("have = %q, want %q", have, want)
+		}
+	})
+}
+
+func TestForEachTransferErrors(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		if err := PutTransfer(store, &Transfer{SourceTx: common.HexToHash("0x1"), State: TransferConfirmed}); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("Returns the errors of fn as they are", func(t *testing.T) {
+			want := errors.New("stop")
+			if err := ForEachTransfer(store, func(*Transfer) error { return want }); err != want {
+				t.Errorf("have = %v, want %v", err, want)
+			}
+		})
+
+		t.Run("Returns store errors for unreadable records", func(t *testing.T) {
+			store.Put(transferKey(common.HexToHash("0x2")), []byte("{"))
+			err := ForEachTransfer(store, func(*Transfer) error { return nil })
+			if !IsKind(err, ErrStore) {
+				t.Errorf("have = %v, want a store error", err)
+			}
+		})
+	})
+}
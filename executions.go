@@ -0,0 +1,123 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// executionIndexBlock is the key of the last block of the main chain whose Execution events
+// are in the index
+const executionIndexBlock = "ExecutionIndex"
+
+// Execution is the Execution event of a withdrawal on the main chain wallet
+type Execution struct {
+	Block  uint64      `json:"block"`
+	TxHash common.Hash `json:"txHash"`
+}
+
+func executionKey(txHash common.Hash) string {
+	return "executions/" + txHash.Hex()
+}
+
+// GetExecution returns the execution of the withdrawal of txHash found in the index, nil if
+// there is none
+func GetExecution(store Store, txHash common.Hash) (*Execution, error) {
+	c, err := store.Get(executionKey(txHash))
+	if err != nil || c == nil {
+		return nil, storeError("get execution", err)
+	}
+	var e Execution
+	if err := json.Unmarshal(c, &e); err != nil {
+		return nil, storeError("get execution", err)
+	}
+	return &e, nil
+}
+
+// IndexExecutions adds the Execution events of the main chain to the index, from the last
+// indexed block to end. Like the signatures, the whole history is only read the first time.
+func IndexExecutions(ctx context.Context, mc MainChainEvents, store Store, scanner *LogScanner, end uint64) error {
+	start, err := GetLastProcessedBlock(store, executionIndexBlock)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return nil
+	}
+
+	return scanner.Backfill(start, &end, func(from uint64, to *uint64) (func() error, error) {
+		events, err := mc.Executions(&bind.FilterOpts{Start: from, End: to, Context: ctx}, nil)
+		if err != nil {
+			return nil, rpcError("filter executions", err)
+		}
+		return func() error {
+			err := store.Update(func(tx StoreTx) error {
+				for _, event := range events {
+					c, err := json.Marshal(Execution{Block: event.Raw.BlockNumber, TxHash: event.Raw.TxHash})
+					if err != nil {
+						return err
+					}
+					if err := tx.Put(executionKey(event.TxHash), c); err != nil {
+						return err
+					}
+				}
+				return advanceLastBlock(tx, executionIndexBlock, *to)
+			})
+			return storeError("index executions", err)
+		}, nil
+	})
+}
+
+// rollbackExecutions removes from the index the executions after block, which a
+// reorganization dropped from the main chain, and restarts the index from block
+func rollbackExecutions(store Store, block uint64) error {
+	var keys []string
+	err := store.ForEach("executions/", func(key string, value []byte) error {
+		var e Execution
+		if err := json.Unmarshal(value, &e); err != nil {
+			return err
+		}
+		if e.Block > block {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return storeError("read executions", err)
+	}
+
+	err = store.Update(func(tx StoreTx) error {
+		for _, key := range keys {
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		last, err := getLastBlock(tx, executionIndexBlock)
+		if err != nil || last <= block {
+			return err
+		}
+		return putLastBlock(tx, executionIndexBlock, block)
+	})
+	return storeError("roll back executions", err)
+}
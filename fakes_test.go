@@ -0,0 +1,113 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"math/big"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeChain is a chain made of the given headers and receipts. Its head is the next one of heads
+// on each call, the context being cancelled after the last one, or else the highest header.
+// The methods of Client it doesn't implement panic.
+type fakeChain struct {
+	Client
+	headers  map[uint64]*types.Header
+	receipts map[common.Hash]*types.Receipt
+	// pending are the transactions waiting to be mined
+	pending map[common.Hash]*types.Transaction
+	heads   []int64
+	cancel  context.CancelFunc
+	balance int64
+	// err fails every call
+	err error
+}
+
+func (f *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if number == nil {
+		number = f.head()
+		if header, ok := f.headers[number.Uint64()]; ok {
+			return header, nil
+		}
+		return &types.Header{Number: number}, nil
+	}
+	header, ok := f.headers[number.Uint64()]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
+
+func (f *fakeChain) head() *big.Int {
+	if len(f.heads) > 0 {
+		head := f.heads[0]
+		if len(f.heads) > 1 {
+			f.heads = f.heads[1:]
+		} else if f.cancel != nil {
+			f.cancel()
+		}
+		return big.NewInt(head)
+	}
+	head := new(big.Int)
+	for n := range f.headers {
+		if n > head.Uint64() {
+			head.SetUint64(n)
+		}
+	}
+	return head
+}
+
+func (f *fakeChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	receipt, ok := f.receipts[txHash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (f *fakeChain) TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error) {
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	if tx, ok := f.pending[txHash]; ok {
+		return tx, true, nil
+	}
+	if _, ok := f.receipts[txHash]; ok {
+		return nil, false, nil
+	}
+	return nil, false, ethereum.NotFound
+}
+
+func (f *fakeChain) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return big.NewInt(f.balance), nil
+}
@@ -0,0 +1,362 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Gas price modes
+const (
+	// GasSuggested pays the price suggested by the node, times a multiplier
+	GasSuggested = "suggested"
+	// GasFixed always pays the same price
+	GasFixed = "fixed"
+	// GasZero sends free transactions, for PoA chains whose sealers accept them
+	GasZero = "zero"
+)
+
+// replacementBump is how much more a replacement transaction pays. Nodes require at
+// least 10% more than the transaction being replaced.
+const replacementBump = 1.125
+
+// GasPolicy tells which gas price to pay for the transactions sent on a chain
+type GasPolicy struct {
+	// Mode is GasSuggested, GasFixed or GasZero. GasSuggested is used if it is empty.
+	Mode string
+	// Price is the price paid in the GasFixed mode
+	Price *big.Int
+	// Multiplier is applied to the suggested price, 1 if zero
+	Multiplier float64
+	// Cap is the highest price ever paid, no limit if nil
+	Cap *big.Int
+}
+
+// GasPricer suggests gas prices, like ethclient.Client
+type GasPricer interface {
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+}
+
+// GasPrice returns the price to pay for a new transaction
+func (p GasPolicy) GasPrice(ctx context.Context, client GasPricer) (*big.Int, error) {
+	switch p.Mode {
+	case GasZero:
+		return new(big.Int), nil
+	case GasFixed:
+		if p.Price == nil {
+			return nil, errors.New("no gas price given for the fixed mode")
+		}
+		return p.capped(p.Price), nil
+	case GasSuggested, "":
+		price, err := client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, rpcError("suggest gas price", err)
+		}
+		if p.Multiplier != 0 {
+			price = mulPrice(price, p.Multiplier)
+		}
+		return p.capped(price), nil
+	}
+	return nil, fmt.Errorf("unknown gas price mode %q", p.Mode)
+}
+
+func (p GasPolicy) capped(price *big.Int) *big.Int {
+	if p.Cap != nil && price.Cmp(p.Cap) > 0 {
+		return new(big.Int).Set(p.Cap)
+	}
+	return new(big.Int).Set(price)
+}
+
+// mulPrice multiplies a gas price, rounding up
+func mulPrice(price *big.Int, m float64) *big.Int {
+	f := new(big.Float).Mul(new(big.Float).SetInt(price), big.NewFloat(m))
+	n, acc := f.Int(nil)
+	if acc == big.Below {
+		n.Add(n, big.NewInt(1))
+	}
+	return n
+}
+
+// replacementPrice returns the price to pay to replace a transaction paying old
+func (p GasPolicy) replacementPrice(ctx context.Context, client GasPricer, old *big.Int) (*big.Int, error) {
+	if p.Mode == GasZero {
+		return nil, errors.New("free transactions can't be replaced")
+	}
+	price, err := p.GasPrice(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	bumped := mulPrice(old, replacementBump)
+	if price.Cmp(bumped) < 0 {
+		price = bumped
+	}
+	if p.Cap != nil && price.Cmp(p.Cap) > 0 {
+		return nil, fmt.Errorf("replacement gas price %v is above the cap %v", price, p.Cap)
+	}
+	return price, nil
+}
+
+// Sender sends the transactions of the sealer on one chain
+type Sender struct {
+	Auth   *bind.TransactOpts
+	Client bind.ContractTransactor
+	Gas    GasPolicy
+	// Nonces hands out the nonces of the sealer on the chain. If nil, each transaction
+	// gets the pending nonce of the account.
+	Nonces *NonceManager
+	// Grace is how long a transaction being sent may still take once the context of
+	// Transact is cancelled, so that it is either sent and recorded, or not sent at all.
+	// If 0, the transaction is abandoned with the context.
+	Grace time.Duration
+}
+
+// Opts returns the options to send a new transaction, with the gas price of the policy
+func (s *Sender) Opts(ctx context.Context) (*bind.TransactOpts, error) {
+	price, err := s.Gas.GasPrice(ctx, s.Client)
+	if err != nil {
+		return nil, err
+	}
+	opts := *s.Auth
+	opts.GasPrice = price
+	opts.Context = ctx
+	return &opts, nil
+}
+
+// Transact calls send with the options to send a new transaction: the gas price of the
+// policy, and the next nonce of the sealer
+func (s *Sender) Transact(ctx context.Context, send func(opts *bind.TransactOpts) (*types.Transaction, error)) (*types.Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.Grace > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = inFlight(ctx, s.Grace)
+		defer cancel()
+	}
+	opts, err := s.Opts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.Nonces == nil {
+		tx, err := send(opts)
+		return tx, txError("send transaction", err)
+	}
+	tx, err := s.Nonces.Send(ctx, func(nonce uint64) (*types.Transaction, error) {
+		opts.Nonce = new(big.Int).SetUint64(nonce)
+		return send(opts)
+	})
+	return tx, txError("send transaction", err)
+}
+
+// inFlight returns a context that is cancelled grace after ctx, or when the returned
+// function is called
+func inFlight(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	flight, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-flight.Done():
+			return
+		}
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-flight.Done():
+		}
+	}()
+	return flight, cancel
+}
+
+// SyncNonce syncs the nonce manager of the sender, if it has one
+func (s *Sender) SyncNonce(ctx context.Context) error {
+	if s.Nonces == nil {
+		return nil
+	}
+	return s.Nonces.Sync(ctx)
+}
+
+// replace sends tx again with the same nonce and a higher gas price
+func (s *Sender) replace(ctx context.Context, tx *types.Transaction) (*types.Transaction, error) {
+	price, err := s.Gas.replacementPrice(ctx, s.Client, tx.GasPrice())
+	if err != nil {
+		return nil, err
+	}
+	replacement := types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), price, tx.Data())
+	signed, err := s.Auth.Signer(types.HomesteadSigner{}, s.Auth.From, replacement)
+	if err != nil {
+		return nil, err
+	}
+	return signed, txError("send replacement", s.Client.SendTransaction(ctx, signed))
+}
+
+// PendingReader finds out what became of the transactions sent, like ethclient.Client
+type PendingReader interface {
+	ChainReader
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+}
+
+func pendingKey(txHash common.Hash) string {
+	return "pending/" + txHash.Hex()
+}
+
+// ReplaceStuckTransactions replaces the votes, signatures and withdrawals of the sealer
+// that are still pending after the given number of blocks. The block at which a transaction
+// was first seen pending is saved in the store, so that the wait spans several runs.
+// Transactions the node doesn't know, because it dropped them or never got them, are waited
+// for the same way, and then queued to be sent again.
+func ReplaceStuckTransactions(ctx context.Context, mcSender *Sender, scSender *Sender,
+	mcClient PendingReader, scClient PendingReader, store Store, blocks uint64) error {
+	var pending []*Transfer
+	err := ForEachTransfer(store, func(t *Transfer) error {
+		if (t.State == TransferVoted || t.State == TransferSubmitted) && t.DestTx != (common.Hash{}) {
+			pending = append(pending, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range pending {
+		// Only the withdrawals are sent to the main chain
+		sender, client := scSender, scClient
+		if t.State == TransferSubmitted {
+			sender, client = mcSender, mcClient
+		}
+		if err := replaceStuck(ctx, sender, client, store, t, blocks); err != nil {
+			transferLogger(t.Direction, t.SourceTx, t.SourceBlock).With(Fields{"destTx": t.DestTx}).WithError(err).Error("can't check the pending transaction")
+		}
+	}
+	return nil
+}
+
+func replaceStuck(ctx context.Context, sender *Sender, client PendingReader,
+	store Store, t *Transfer, blocks uint64) error {
+	seen, err := store.Get(pendingKey(t.DestTx))
+	if err != nil {
+		return err
+	}
+	pendingTx, isPending, err := client.TransactionByHash(ctx, t.DestTx)
+	if err != nil && err != ethereum.NotFound {
+		return err
+	}
+	missing := err == ethereum.NotFound
+	if !missing && !isPending {
+		if seen == nil {
+			return nil
+		}
+		return store.Update(func(tx StoreTx) error {
+			return tx.Delete(pendingKey(t.DestTx))
+		})
+	}
+
+	head, err := headNumber(ctx, client)
+	if err != nil {
+		return err
+	}
+	if seen == nil {
+		return store.Put(pendingKey(t.DestTx), []byte(strconv.FormatUint(head, 10)))
+	}
+	since, err := strconv.ParseUint(string(seen), 10, 64)
+	if err != nil {
+		return err
+	}
+	if head < since+blocks {
+		return nil
+	}
+	if missing {
+		return dropTransaction(store, t)
+	}
+
+	replacement, err := sender.replace(ctx, pendingTx)
+	if err != nil {
+		return err
+	}
+	transferLogger(t.Direction, t.SourceTx, t.SourceBlock).With(Fields{
+		"destTx":       t.DestTx,
+		"pendingSince": since,
+		"replacement":  replacement.Hash(),
+		"gasPrice":     replacement.GasPrice(),
+	}).Warn("replaced a stuck transaction")
+	old := t.DestTx
+	if err := t.Advance(t.State, replacement.Hash(), nil); err != nil {
+		return err
+	}
+	c, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	err = store.Update(func(tx StoreTx) error {
+		if err := tx.Delete(pendingKey(old)); err != nil {
+			return err
+		}
+		if err := tx.Put(pendingKey(replacement.Hash()), []byte(strconv.FormatUint(head, 10))); err != nil {
+			return err
+		}
+		return tx.Put(transferKey(t.SourceTx), c)
+	})
+	if err != nil {
+		return err
+	}
+	observeSteps(t)
+	return nil
+}
+
+var errDropped = errors.New("transaction dropped by the node")
+
+// dropTransaction records that the last transaction of a transfer was dropped by the node
+// while it was pending, and queues the transfer to be sent again
+func dropTransaction(store Store, t *Transfer) error {
+	logger := transferLogger(t.Direction, t.SourceTx, t.SourceBlock).With(Fields{"destTx": t.DestTx})
+	logger.Warn("transaction dropped")
+	entry := &RetryEntry{Kind: retryKind(t), SourceTx: t.SourceTx, SourceBlock: t.SourceBlock, To: t.To, Value: t.Value}
+	dropped := t.DestTx
+	if err := t.Advance(TransferFailed, common.Hash{}, errDropped); err != nil {
+		return err
+	}
+	c, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	err = store.Update(func(tx StoreTx) error {
+		if err := tx.Delete(pendingKey(dropped)); err != nil {
+			return err
+		}
+		return tx.Put(transferKey(t.SourceTx), c)
+	})
+	if err != nil {
+		return err
+	}
+	observeSteps(t)
+	return scheduleRetry(store, logger, entry, errDropped)
+}
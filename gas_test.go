@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type fakePricer int64
+
+func (p fakePricer) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(int64(p)), nil
+}
+
+func TestGasPolicy(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		policy GasPolicy
+		want   *big.Int
+	}{
+		{"Suggested price by default", GasPolicy{}, big.NewInt(1000)},
+		{"Suggested price times the multiplier", GasPolicy{Mode: GasSuggested, Multiplier: 1.5}, big.NewInt(1500)},
+		{"Suggested price is rounded up", GasPolicy{Mode: GasSuggested, Multiplier: 1.0001}, big.NewInt(1001)},
+		{"Suggested price is capped", GasPolicy{Mode: GasSuggested, Multiplier: 3, Cap: big.NewInt(2000)}, big.NewInt(2000)},
+		{"Fixed price", GasPolicy{Mode: GasFixed, Price: big.NewInt(42)}, big.NewInt(42)},
+		{"Zero price", GasPolicy{Mode: GasZero}, big.NewInt(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have, err := tt.policy.GasPrice(ctx, fakePricer(1000))
+			if err != nil || have.Cmp(tt.want) != 0 {
+				t.Errorf("have = %v, %v, want %v, %v", have, err, tt.want, nil)
+			}
+		})
+	}
+
+	t.Run("Fixed price needs a price", func(t *testing.T) {
+		if _, err := (GasPolicy{Mode: GasFixed}).GasPrice(ctx, fakePricer(1000)); err == nil {
+			t.Errorf("have = %v, want an error", err)
+		}
+	})
+}
+
+func TestReplacementPrice(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		policy GasPolicy
+		old    *big.Int
+		want   *big.Int
+	}{
+		{"Pays the current price if it is high enough", GasPolicy{}, big.NewInt(500), big.NewInt(1000)},
+		{"Pays more than the replaced transaction", GasPolicy{}, big.NewInt(1000), big.NewInt(1125)},
+		{"Can't go above the cap", GasPolicy{Cap: big.NewInt(1100)}, big.NewInt(1000), nil},
+		{"Can't replace free transactions", GasPolicy{Mode: GasZero}, big.NewInt(0), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have, err := tt.policy.replacementPrice(ctx, fakePricer(1000), tt.old)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("have = %v, want an error", have)
+				}
+				return
+			}
+			if err != nil || have.Cmp(tt.want) != 0 {
+				t.Errorf("have = %v, %v, want %v, %v", have, err, tt.want, nil)
+			}
+		})
+	}
+}
+
+func TestTransactShutdown(t *testing.T) {
+	sender := &Sender{Auth: &bind.TransactOpts{}, Gas: GasPolicy{Mode: GasZero}, Grace: 20 * time.Millisecond}
+
+	t.Run("Nothing is sent once the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		sent := false
+		_, err := sender.Transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			sent = true
+			return nil, nil
+		})
+		if sent || err != context.Canceled {
+			t.Errorf("have = %v, %v, want %v, %v", sent, err, false, context.Canceled)
+		}
+	})
+
+	t.Run("A transaction being sent outlives the context by the grace", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := sender.Transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			cancel()
+			time.Sleep(5 * time.Millisecond)
+			if err := opts.Context.Err(); err != nil {
+				t.Errorf("have = %v, want %v", err, nil)
+			}
+			select {
+			case <-opts.Context.Done():
+			case <-time.After(time.Second):
+				t.Errorf("the send was not cancelled after the grace")
+			}
+			return nil, nil
+		})
+		if err != nil {
+			t.Errorf("have = %v, want %v", err, nil)
+		}
+	})
+}
+
+func TestReplaceStuckMissing(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		vote := &Transfer{Direction: MainChainToSideChain, SourceTx: common.HexToHash("0x1"), SourceBlock: 3, Value: big.NewInt(1), State: TransferObserved}
+		vote.Advance(TransferConfirmed, common.Hash{}, nil)
+		vote.Advance(TransferVoted, common.HexToHash("0x2"), nil)
+		PutTransfer(store, vote)
+		// The node never got the vote
+		chain := &fakeChain{heads: []int64{10}}
+
+		t.Run("Waits for a missing transaction like for a pending one", func(t *testing.T) {
+			if err := replaceStuck(context.Background(), nil, chain, store, vote, 5); err != nil {
+				t.Fatal(err)
+			}
+			if c, _ := store.Get(pendingKey(vote.DestTx)); string(c) != "10" {
+				t.Errorf("have = %s, want %v", c, 10)
+			}
+			if have, _ := GetTransfer(store, vote.SourceTx); have.State != TransferVoted {
+				t.Errorf("have = %v, want %v", have.State, TransferVoted)
+			}
+		})
+
+		t.Run("Sends it again once it is stuck", func(t *testing.T) {
+			chain.heads = []int64{15}
+			if err := replaceStuck(context.Background(), nil, chain, store, vote, 5); err != nil {
+				t.Fatal(err)
+			}
+			if have, _ := GetTransfer(store, vote.SourceTx); have.State != TransferFailed {
+				t.Errorf("have = %v, want %v", have.State, TransferFailed)
+			}
+			entry := &RetryEntry{Kind: RetryVote, SourceTx: vote.SourceTx}
+			if c, _ := store.Get(entry.key("retry/")); c == nil {
+				t.Errorf("have = %v, want a retry", c)
+			}
+		})
+	})
+}
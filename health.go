@@ -0,0 +1,162 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BalanceReader reads the balance of an account, like ethclient.Client
+type BalanceReader interface {
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// HealthCheck is the result of one check of the health of a relayer
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthReport is the result of the checks of a relayer. It is OK if all the checks are.
+type HealthReport struct {
+	OK     bool          `json:"ok"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+func (h *HealthReport) add(name string, err error) {
+	check := HealthCheck{Name: name, OK: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	h.Checks = append(h.Checks, check)
+	h.OK = h.OK && check.OK
+}
+
+// Health checks that the relayer is running without errors, that the endpoints of both
+// chains answer and that the store can be read. A relayer that fails them needs a restart.
+func (r *Relayer) Health(ctx context.Context) HealthReport {
+	report := HealthReport{OK: true}
+	status, err := r.Status()
+	if err == nil {
+		err = status.Err
+	}
+	if err == nil && !status.Running {
+		err = fmt.Errorf("relayer not running")
+	}
+	report.add("relayer", err)
+	for _, chain := range []string{mainChainLabel, sideChainLabel} {
+		_, err := headNumber(ctx, r.client(chain))
+		report.add(chain, err)
+	}
+	report.add("store", checkStore(r.config.Store))
+	return report
+}
+
+// Ready checks, on top of Health, that the events are processed no more than the maximum lag
+// behind the confirmed head of their chain, and that the sealer can pay for its transactions
+// on each chain
+func (r *Relayer) Ready(ctx context.Context) HealthReport {
+	report := r.Health(ctx)
+	if r.maxLag > 0 {
+		for _, eventType := range r.eventTypes() {
+			report.add("lag "+eventType, r.checkLag(ctx, eventType))
+		}
+	}
+	for _, chain := range []string{mainChainLabel, sideChainLabel} {
+		report.add("balance "+chain, r.checkBalance(ctx, chain))
+	}
+	return report
+}
+
+// client returns the client of chain
+func (r *Relayer) client(chain string) Client {
+	if chain == mainChainLabel {
+		return r.config.MainChainClient
+	}
+	return r.config.SideChainClient
+}
+
+// checkLag fails if eventType is more than the maximum lag behind the confirmed head of its chain
+func (r *Relayer) checkLag(ctx context.Context, eventType string) error {
+	chain := eventChains[eventType]
+	confirmations := r.scConfirmations
+	if chain == mainChainLabel {
+		confirmations = r.mcConfirmations
+	}
+	lag, err := eventLag(ctx, r.client(chain), r.config.Store, eventType)
+	if err != nil {
+		return err
+	}
+	if lag > confirmations+r.maxLag {
+		return fmt.Errorf("%d blocks behind the head, more than %d", lag, confirmations+r.maxLag)
+	}
+	return nil
+}
+
+// checkBalance fails if the sealer has less than the minimum balance on chain
+func (r *Relayer) checkBalance(ctx context.Context, chain string) error {
+	min := r.scMinBalance
+	if chain == mainChainLabel {
+		min = r.mcMinBalance
+	}
+	balance, err := r.client(chain).BalanceAt(ctx, r.sealer, nil)
+	if err != nil {
+		return rpcError("get balance", err)
+	}
+	if min != nil && balance.Cmp(min) < 0 {
+		return fmt.Errorf("balance of %v wei, less than %v", balance, min)
+	}
+	return nil
+}
+
+// checkStore reads the last processed block of each kind of event. Nothing is written, as
+// the checks may run every second: a store that can't be written holds back the checkpoints,
+// which the lag checks of Ready report.
+func checkStore(store Store) error {
+	for eventType := range eventChains {
+		if _, err := GetLastProcessedBlock(store, eventType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HealthHandler serves the report of check as JSON, with the status 200 if it is OK or
+// 503 otherwise. The checks are given timeout to complete.
+func HealthHandler(check func(ctx context.Context) HealthReport, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		report := check(ctx)
+		w.Header().Set("Content-Type", "application/json")
+		if !report.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// unreadableStore fails every read
+type unreadableStore struct {
+	Store
+}
+
+func (s unreadableStore) Get(key string) ([]byte, error) {
+	return nil, errors.New("input/output error")
+}
+
+func TestRelayerHealth(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		newRelayer := func(mc *fakeChain, sc *fakeChain, opts ...Option) *Relayer {
+			r := &Relayer{
+				config:   Config{MainChainClient: mc, SideChainClient: sc, Store: store},
+				mcSender: &Sender{},
+				scSender: &Sender{},
+				maxLag:   100,
+			}
+			for _, opt := range append([]Option{WithChains(false, false)}, opts...) {
+				opt(r)
+			}
+			return r
+		}
+		check := func(report HealthReport, name string) HealthCheck {
+			for _, c := range report.Checks {
+				if c.Name == name {
+					return c
+				}
+			}
+			t.Fatalf("no check %s in %+v", name, report)
+			return HealthCheck{}
+		}
+
+		t.Run("Healthy and ready while running", func(t *testing.T) {
+			r := newRelayer(&fakeChain{heads: []int64{10}, balance: 5}, &fakeChain{heads: []int64{20}, balance: 5},
+				WithWatch(time.Millisecond), WithHealth(100, big.NewInt(5), big.NewInt(5)))
+			if err := r.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			defer r.Stop()
+			if report := r.Health(context.Background()); !report.OK {
+				t.Errorf("have = %+v, want healthy", report)
+			}
+			if report := r.Ready(context.Background()); !report.OK {
+				t.Errorf("have = %+v, want ready", report)
+			}
+		})
+
+		t.Run("Not healthy when stopped", func(t *testing.T) {
+			r := newRelayer(&fakeChain{heads: []int64{10}}, &fakeChain{heads: []int64{20}})
+			report := r.Health(context.Background())
+			if report.OK || check(report, "relayer").OK || !check(report, "store").OK {
+				t.Errorf("have = %+v, want only the relayer check to fail", report)
+			}
+		})
+
+		t.Run("Only reads the store", func(t *testing.T) {
+			r := newRelayer(&fakeChain{heads: []int64{10}}, &fakeChain{heads: []int64{20}})
+			r.config.Store = readOnlyStore{store}
+			if c := check(r.Health(context.Background()), "store"); !c.OK {
+				t.Errorf("have = %+v, want a passed check", c)
+			}
+			r.config.Store = unreadableStore{store}
+			if c := check(r.Health(context.Background()), "store"); c.OK {
+				t.Errorf("have = %+v, want a failed check", c)
+			}
+		})
+
+		t.Run("Not healthy when an endpoint is down", func(t *testing.T) {
+			r := newRelayer(&fakeChain{heads: []int64{10}}, &fakeChain{err: errors.New("connection refused")})
+			report := r.Health(context.Background())
+			if !check(report, "mainchain").OK || check(report, "sidechain").OK {
+				t.Errorf("have = %+v, want the sidechain check to fail", report)
+			}
+		})
+
+		t.Run("Not ready when lagging beyond the confirmations", func(t *testing.T) {
+			PersistLastBlock(store, "MCDeposit", 100)
+			r := newRelayer(&fakeChain{heads: []int64{300}}, &fakeChain{heads: []int64{20}}, WithChains(true, false))
+			if c := check(r.Ready(context.Background()), "lag MCDeposit"); c.OK {
+				t.Errorf("have = %+v, want a failed check", c)
+			}
+			r = newRelayer(&fakeChain{heads: []int64{300}}, &fakeChain{heads: []int64{20}}, WithChains(true, false), WithConfirmations(100, 0))
+			if c := check(r.Ready(context.Background()), "lag MCDeposit"); !c.OK {
+				t.Errorf("have = %+v, want a passed check", c)
+			}
+		})
+
+		t.Run("Not ready when the balance is too low", func(t *testing.T) {
+			r := newRelayer(&fakeChain{balance: 5}, &fakeChain{balance: 0}, WithHealth(100, big.NewInt(5), big.NewInt(1)))
+			report := r.Ready(context.Background())
+			if !check(report, "balance mainchain").OK || check(report, "balance sidechain").OK {
+				t.Errorf("have = %+v, want the sidechain balance check to fail", report)
+			}
+		})
+	})
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		report HealthReport
+		want   int
+	}{
+		{"OK", HealthReport{OK: true}, http.StatusOK},
+		{"Failed", HealthReport{OK: false, Checks: []HealthCheck{{Name: "store", Error: "disk full"}}}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := HealthHandler(func(ctx context.Context) HealthReport { return tt.report }, time.Second)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != tt.want {
+				t.Errorf("have = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
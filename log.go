@@ -0,0 +1,213 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Levels of the log entries, from the most verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s: debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.ToLower(s) == name {
+			return Level(l), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Formats of the log entries
+const (
+	// FormatLogfmt writes each entry as a line of key=value pairs
+	FormatLogfmt = "logfmt"
+	// FormatJSON writes each entry as a JSON object on its own line
+	FormatJSON = "json"
+)
+
+// Fields are the context of a log entry. The node uses the same names everywhere, so that
+// the entries of a transfer can be found by its source transaction:
+//
+//	component   part of the node, for the entries that are not about one transfer
+//	direction   mc2sc or sc2mc
+//	sourceTx    hash of the deposit transaction
+//	block       block of the event being processed
+//	destTx      hash of the transaction sent for the transfer
+//	attempt     number of the attempt of a submission
+//	error       message of the error
+//	errorClass  rpc, store, revert or other, see IsKind
+type Fields map[string]interface{}
+
+// Logger writes leveled log entries with fields, as logfmt or JSON. Loggers made with With
+// share the output of their parent and are safe for concurrent use.
+type Logger struct {
+	out    *logOutput
+	level  Level
+	format string
+	fields Fields
+}
+
+type logOutput struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// NewLogger returns a logger writing the entries of level and above to w in format
+func NewLogger(w io.Writer, level Level, format string) *Logger {
+	return &Logger{out: &logOutput{w: w}, level: level, format: format}
+}
+
+// std is the logger of the node
+var std = NewLogger(os.Stderr, LevelInfo, FormatLogfmt)
+
+// SetLogger replaces the logger of the node. It must be called before the node starts.
+func SetLogger(l *Logger) {
+	std = l
+}
+
+// With returns a logger adding fields to the entries of l
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{out: l.out, level: l.level, format: l.format, fields: merged}
+}
+
+// WithError returns a logger adding err and its class to the entries of l
+func (l *Logger) WithError(err error) *Logger {
+	return l.With(Fields{"error": err.Error(), "errorClass": errorCategory(err)})
+}
+
+// Debug logs msg at the debug level
+func (l *Logger) Debug(msg string) { l.log(LevelDebug, msg) }
+
+// Info logs msg at the info level
+func (l *Logger) Info(msg string) { l.log(LevelInfo, msg) }
+
+// Warn logs msg at the warn level
+func (l *Logger) Warn(msg string) { l.log(LevelWarn, msg) }
+
+// Error logs msg at the error level
+func (l *Logger) Error(msg string) { l.log(LevelError, msg) }
+
+func (l *Logger) log(level Level, msg string) {
+	if level < l.level {
+		return
+	}
+	now := time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	var buf bytes.Buffer
+	if l.format == FormatJSON {
+		entry := make(Fields, len(l.fields)+3)
+		for k, v := range l.fields {
+			entry[k] = v
+		}
+		entry["time"], entry["level"], entry["msg"] = now, level.String(), msg
+		if err := json.NewEncoder(&buf).Encode(entry); err != nil {
+			return
+		}
+	} else {
+		writeLogfmt(&buf, "time", now)
+		writeLogfmt(&buf, "level", level.String())
+		writeLogfmt(&buf, "msg", msg)
+		keys := make([]string, 0, len(l.fields))
+		for k := range l.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeLogfmt(&buf, k, fmt.Sprint(l.fields[k]))
+		}
+		buf.WriteByte('\n')
+	}
+	l.out.Lock()
+	defer l.out.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// writeLogfmt appends a key=value pair to buf, quoting the value if needed
+func writeLogfmt(buf *bytes.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " =\"\\") || strconv.Quote(value) != `"`+value+`"` {
+		value = strconv.Quote(value)
+	}
+	buf.WriteString(value)
+}
+
+// componentLogger returns the logger of a part of the node
+func componentLogger(component string) *Logger {
+	return std.With(Fields{"component": component})
+}
+
+// transferLogger returns the logger of the transfer of sourceTx, found in block.
+// The block is left out if it is unknown.
+func transferLogger(direction string, sourceTx common.Hash, block uint64) *Logger {
+	fields := Fields{"direction": direction, "sourceTx": sourceTx}
+	if block != 0 {
+		fields["block"] = block
+	}
+	return std.With(fields)
+}
+
+// withTx returns a logger adding the transaction sent for a transfer, if any
+func withTx(l *Logger, tx *types.Transaction) *Logger {
+	if tx == nil {
+		return l
+	}
+	return l.With(Fields{"destTx": tx.Hash()})
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLogger(t *testing.T) {
+	sourceTx := common.HexToHash("0x01")
+	tests := []struct {
+		name   string
+		level  Level
+		format string
+		log    func(l *Logger)
+		want   string
+	}{
+		{
+			name:   "Fields are sorted after the message",
+			level:  LevelInfo,
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.With(Fields{"direction": MainChainToSideChain, "block": 12}).Info("voted")
+			},
+			want: `^time=\S+ level=info msg=voted block=12 direction=mc2sc\n$`,
+		},
+		{
+			name:   "Values are quoted when needed",
+			level:  LevelInfo,
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.With(Fields{"empty": "", "quote": `say "hi"`}).Warn("skipped, already done")
+			},
+			want: `^time=\S+ level=warn msg="skipped, already done" empty="" quote="say \\"hi\\""\n$`,
+		},
+		{
+			name:   "Errors come with their class",
+			level:  LevelInfo,
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.WithError(rpcError("get head", errors.New("connection refused"))).Error("can't vote")
+			},
+			want: `^time=\S+ level=error msg="can't vote" error="get head: connection refused" errorClass=rpc\n$`,
+		},
+		{
+			name:   "Entries below the level are left out",
+			level:  LevelWarn,
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.Debug("left to the designated submitter")
+				l.Info("voted")
+			},
+			want: `^$`,
+		},
+		{
+			name:   "Hashes are written in hex",
+			level:  LevelDebug,
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.With(Fields{"sourceTx": sourceTx}).Debug("resuming")
+			},
+			want: `^time=\S+ level=debug msg=resuming sourceTx=` + sourceTx.Hex() + `\n$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(NewLogger(&buf, tt.level, tt.format))
+			if !regexp.MustCompile(tt.want).Match(buf.Bytes()) {
+				t.Errorf("have = %q, want %v", buf.String(), tt.want)
+			}
+		})
+	}
+
+	t.Run("JSON entries are objects", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := NewLogger(&buf, LevelInfo, FormatJSON).With(Fields{"direction": SideChainToMainChain, "sourceTx": sourceTx})
+		l.With(Fields{"attempt": 2}).Info("retrying")
+		var have map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &have); err != nil {
+			t.Fatal(err)
+		}
+		delete(have, "time")
+		want := map[string]interface{}{"level": "info", "msg": "retrying", "direction": "sc2mc", "sourceTx": sourceTx.Hex(), "attempt": float64(2)}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("have = %v, want %v", have, want)
+		}
+	})
+
+	t.Run("With leaves the parent alone", func(t *testing.T) {
+		var buf bytes.Buffer
+		parent := NewLogger(&buf, LevelInfo, FormatLogfmt).With(Fields{"direction": MainChainToSideChain})
+		parent.With(Fields{"destTx": sourceTx})
+		parent.Info("voted")
+		if regexp.MustCompile(`destTx`).Match(buf.Bytes()) {
+			t.Errorf("have = %q, want no destTx", buf.String())
+		}
+	})
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"WARN", LevelWarn, false},
+		{"verbose", LevelInfo, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			have, err := ParseLevel(tt.name)
+			if have != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("have = %v, %v, want %v", have, err, tt.want)
+			}
+		})
+	}
+}
@@ -22,12 +22,10 @@ package icn
 import (
 	"context"
 	"crypto/ecdsa"
-	"io/ioutil"
-	"log"
+	"fmt"
 	"math/big"
-	"os"
 	"strconv"
-	"sync"
+	"time"
 
 	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
 	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
@@ -82,7 +80,7 @@ func SubmitSignatureMC(
 	ctx context.Context,
 	sideChainWalletAddress common.Address,
 	auth *bind.TransactOpts,
-	sc *sidechain.SideChain,
+	sc SideChainTransactor,
 	event *sidechain.SideChainDeposit,
 	key *ecdsa.PrivateKey,
 ) (*types.Transaction, error) {
@@ -97,7 +95,8 @@ func SubmitSignatureMC(
 	}
 
 	// Submit the signature
-	return sc.SubmitSignatureMC(auth, event.Raw.TxHash, event.To, event.Value, data, v, r, s)
+	tx, err := sc.SubmitSignatureMC(auth, event.Raw.TxHash, event.To, event.Value, data, v, r, s)
+	return tx, txError("submit signature", err)
 }
 
 // Sign signs a msgHash and return the v r s signature
@@ -115,113 +114,539 @@ func Sign(msgHash common.Hash, key *ecdsa.PrivateKey,
 	return
 }
 
-// HasEnoughSignaturesMC checks if a transaction got enough signature to be withdrawn on the main chain
-func HasEnoughSignaturesMC(ctx context.Context, sc *sidechain.SideChain, sealerAddr common.Address, txHash common.Hash) (bool, error) {
-	req, err := sc.Required(&bind.CallOpts{Pending: false, From: sealerAddr, Context: ctx})
+// HasEnoughSignaturesMC checks if a transaction got enough signature to be withdrawn on the main chain:
+// signatures from at least Required distinct owners of the wallet. The signatures are read from
+// the index, see IndexSignatures. The owners and Required are read on each check, so that changes
+// made while the transfer is in flight are taken into account.
+func HasEnoughSignaturesMC(ctx context.Context, sc SideChainCaller, store Store,
+	sideChainWalletAddress common.Address, sealerAddr common.Address, txHash common.Hash) (bool, error) {
+	opts := &bind.CallOpts{Pending: false, From: sealerAddr, Context: ctx}
+	req, err := sc.Required(opts)
 	if err != nil {
+		return false, rpcError("get required signatures", err)
+	}
+
+	sigs, err := GetSignatures(store, txHash)
+	if err != nil || len(sigs) < int(req) {
 		return false, err
 	}
 
-	var count uint8
-	iter, _ := sc.FilterSignatureAdded(&bind.FilterOpts{Start: 0, End: nil, Context: ctx})
-	for iter.Next() {
-		if iter.Event.TxHash == txHash {
-			count++
+	resp, err := sc.GetTransactionMC(opts, txHash)
+	if err != nil {
+		return false, rpcError("get withdrawal", err)
+	}
+	msgHash := MsgHash(sideChainWalletAddress, txHash, resp.Destination, resp.Value, resp.Data, 1)
+	count, err := countOwners(msgHash, sigs, func(signer common.Address) (bool, error) {
+		return sc.IsOwner(opts, signer)
+	})
+	if err != nil {
+		return false, rpcError("check owner", err)
+	}
+
+	return count >= int(req), nil
+}
+
+// countOwners returns the number of distinct owners who signed msgHash. Signatures that
+// can't be recovered are ignored, see recoverSigners.
+func countOwners(msgHash common.Hash, sigs []Signature, isOwner func(common.Address) (bool, error)) (int, error) {
+	owners := make(map[common.Address]bool)
+	for _, signer := range recoverSigners(msgHash, sigs) {
+		if owners[signer] {
+			continue
+		}
+		owner, err := isOwner(signer)
+		if err != nil {
+			return 0, err
+		}
+		if owner {
+			owners[signer] = true
 		}
 	}
+	return len(owners), nil
+}
 
-	return req == count, nil
+// recoverSigners returns the signers of the signatures of msgHash, in order. A signature
+// that can't be recovered is logged and skipped, so that it doesn't block the others.
+func recoverSigners(msgHash common.Hash, sigs []Signature) []common.Address {
+	var signers []common.Address
+	for _, sig := range sigs {
+		signer, err := RecoverSigner(msgHash, sig.V, sig.R, sig.S)
+		if err != nil {
+			std.With(Fields{"direction": SideChainToMainChain, "signatureTx": sig.TxHash}).WithError(err).Warn("invalid signature")
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers
 }
 
-// ProcessMCDeposits watches the main chain and for each Deposit calls SubmitTransactionSC on the side chain
-func ProcessMCDeposits(ctx context.Context, auth *bind.TransactOpts,
-	mc *mainchain.MainChain, sc *sidechain.SideChain,
-	dbPath string, start uint64, end *uint64, wg *sync.WaitGroup) {
-	i, _ := mc.FilterDeposit(&bind.FilterOpts{
-		Start:   start,
-		End:     end,
-		Context: ctx,
-	}, []common.Address{}, []common.Address{})
-	for i.Next() {
-		tx, err := sc.SubmitTransactionSC(auth, i.Event.Raw.TxHash, i.Event.To, i.Event.Value, []byte{})
-		log.Println("[mc2sc]", i.Event.Raw.BlockNumber, tx, err)
-		PersistLastBlock(dbPath, "MCDeposit", i.Event.Raw.BlockNumber)
+// RecoverSigner returns the address of the account that produced the v r s signature of msgHash.
+// A malformed signature is no error of the chain and isn't worth retrying, its error has no kind.
+func RecoverSigner(msgHash common.Hash, v uint8, r, s common.Hash) (common.Address, error) {
+	sig := make([]byte, 65)
+	copy(sig[0:32], r[:])
+	copy(sig[32:64], s[:])
+	sig[64] = v - 27
+
+	pub, err := crypto.SigToPub(msgHash.Bytes(), sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("recover signer: %v", err)
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// SignersMC returns the addresses of the sealers whose signatures of the withdrawal of txHash
+// are stored on the side chain. Like in countOwners, invalid signatures are ignored.
+func SignersMC(ctx context.Context, sc SideChainCaller, sideChainWalletAddress common.Address,
+	sealerAddr common.Address, txHash common.Hash) ([]common.Address, error) {
+	resp, err := sc.GetTransactionMC(&bind.CallOpts{Pending: false, From: sealerAddr, Context: ctx}, txHash)
+	if err != nil {
+		return nil, rpcError("get withdrawal", err)
+	}
+
+	msgHash := MsgHash(sideChainWalletAddress, txHash, resp.Destination, resp.Value, resp.Data, 1)
+	sigs := make([]Signature, len(resp.V))
+	for i := range resp.V {
+		sigs[i] = Signature{V: resp.V[i], R: resp.R[i], S: resp.S[i]}
+	}
+	return recoverSigners(msgHash, sigs), nil
+}
+
+// relayOutcome is what happened to an event handled by a processor
+type relayOutcome int
+
+const (
+	// relaySent means a transaction was sent for the event
+	relaySent relayOutcome = iota
+	// relaySkipped means the work was already done on chain
+	relaySkipped
+	// relayFailed means the transaction couldn't be sent, it is queued for a retry
+	relayFailed
+	// relayWaiting means there is nothing to send yet
+	relayWaiting
+)
+
+// RelayStats counts what happened to the events handled by a processor
+type RelayStats struct {
+	Sent    int
+	Skipped int
+	Failed  int
+}
+
+func (s *RelayStats) add(outcome relayOutcome) {
+	switch outcome {
+	case relaySent:
+		s.Sent++
+	case relaySkipped:
+		s.Skipped++
+	case relayFailed:
+		s.Failed++
+	}
+}
+
+func (s RelayStats) String() string {
+	return fmt.Sprintf("sent %d, skipped %d, failed %d", s.Sent, s.Skipped, s.Failed)
+}
+
+// log logs the counts of a processor
+func (s RelayStats) log(direction string, msg string) {
+	std.With(Fields{"direction": direction, "sent": s.Sent, "skipped": s.Skipped, "failed": s.Failed}).Info(msg)
+}
+
+// ProcessMCDeposits watches the main chain and for each Deposit calls SubmitTransactionSC on the side chain.
+// The deposits that can't be relayed are queued for a retry, the errors returned are those that
+// stop the scan: the events can't be read, a retry can't be queued, or the checkpoint can't be saved.
+func ProcessMCDeposits(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend,
+	store Store, scanner *LogScanner, start uint64, end *uint64) error {
+	var stats RelayStats
+	defer func() { stats.log(MainChainToSideChain, "processed main chain deposits") }()
+
+	cursor, err := GetCursor(store, "MCDeposit")
+	if err != nil {
+		return err
+	}
+	return scanner.Backfill(start, end, func(from uint64, to *uint64) (func() error, error) {
+		deposits, err := mc.Deposits(&bind.FilterOpts{
+			Start:   from,
+			End:     to,
+			Context: ctx,
+		})
+		if err != nil {
+			return nil, rpcError("filter deposits", err)
+		}
+		var events []*mainchain.MainChainDeposit
+		for _, event := range deposits {
+			if !cursor.Handled(event.Raw) {
+				events = append(events, event)
+			}
+		}
+		return func() error {
+			for _, event := range events {
+				// Stop between two events on shutdown, the rest of the window is read again next time
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				outcome, err := relayMCDeposit(ctx, sender, sc, store, event)
+				stats.add(outcome)
+				if err != nil {
+					return err
+				}
+				if err := PersistCheckpoint(store, "MCDeposit", logCheckpoint(event.Raw)); err != nil {
+					return err
+				}
+			}
+			return checkpointWindow(store, "MCDeposit", to)
+		}, nil
+	})
+}
+
+// relayMCDeposit votes on the side chain for a deposit made on the main chain,
+// unless the sealer already voted or the transaction was already executed
+func relayMCDeposit(ctx context.Context, sender *Sender, sc SideChainBackend,
+	store Store, event *mainchain.MainChainDeposit) (relayOutcome, error) {
+	logger := transferLogger(MainChainToSideChain, event.Raw.TxHash, event.Raw.BlockNumber)
+	retry := &RetryEntry{Kind: RetryVote, SourceTx: event.Raw.TxHash, SourceBlock: event.Raw.BlockNumber, To: event.To, Value: event.Value}
+	t, err := observeTransfer(store, MainChainToSideChain, event.Raw, event.To, event.Value)
+	if err != nil {
+		logger.WithError(err).Error("can't record the deposit")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+
+	done, err := votedSC(ctx, sc, sender.Auth.From, event.Raw.TxHash)
+	if err != nil {
+		logger.WithError(err).Error("can't check the vote")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	if done != "" {
+		logger.With(Fields{"state": done}).Info("skipped, already done")
+		skipTransfer(store, logger, t, done)
+		clearRetry(store, logger, retry)
+		return relaySkipped, nil
+	}
+
+	tx, err := sender.Transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return sc.SubmitTransactionSC(opts, event.Raw.TxHash, event.To, event.Value, []byte{})
+	})
+	logger = withTx(logger, tx)
+	saveTransfer(store, logger, t, TransferVoted, tx, err)
+	if err != nil {
+		logger.WithError(err).Error("can't vote")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	logger.Info("voted")
+	clearRetry(store, logger, retry)
+	return relaySent, nil
+}
+
+// votedSC returns TransferExecuted if the transaction txHash was executed on the side chain,
+// TransferVoted if the sealer already voted for it, or an empty state otherwise
+func votedSC(ctx context.Context, sc SideChainCaller, sealerAddr common.Address, txHash common.Hash) (TransferState, error) {
+	opts := &bind.CallOpts{Pending: false, From: sealerAddr, Context: ctx}
+	executed, err := sc.IsConfirmed(opts, txHash)
+	if err != nil || executed {
+		return TransferExecuted, rpcError("check execution", err)
+	}
+	voted, err := sc.Confirmations(opts, txHash, sealerAddr)
+	if err != nil || voted {
+		return TransferVoted, rpcError("check vote", err)
+	}
+	return "", nil
+}
+
+// ProcessSCDeposits watches the side chain and for each Deposit calls SubmitSignatureMC on the side chain.
+// Like ProcessMCDeposits, it only returns the errors that stop the scan.
+func ProcessSCDeposits(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, mcClient HeadReader,
+	addr common.Address, key *ecdsa.PrivateKey,
+	store Store, scanner *LogScanner, start uint64, end *uint64) error {
+	var stats RelayStats
+	defer func() { stats.log(SideChainToMainChain, "processed side chain deposits") }()
+
+	cursor, err := GetCursor(store, "SCDeposit")
+	if err != nil {
+		return err
 	}
-	wg.Done()
+	return scanner.Backfill(start, end, func(from uint64, to *uint64) (func() error, error) {
+		deposits, err := sc.Deposits(&bind.FilterOpts{
+			Start:   from,
+			End:     to,
+			Context: ctx,
+		})
+		if err != nil {
+			return nil, rpcError("filter deposits", err)
+		}
+		var events []*sidechain.SideChainDeposit
+		for _, event := range deposits {
+			if !cursor.Handled(event.Raw) {
+				events = append(events, event)
+			}
+		}
+		return func() error {
+			for _, event := range events {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				outcome, err := relaySCDeposit(ctx, sender, mc, sc, mcClient, addr, key, store, scanner, event)
+				stats.add(outcome)
+				if err != nil {
+					return err
+				}
+				if err := PersistCheckpoint(store, "SCDeposit", logCheckpoint(event.Raw)); err != nil {
+					return err
+				}
+			}
+			return checkpointWindow(store, "SCDeposit", to)
+		}, nil
+	})
 }
 
-// ProcessSCDeposits watches the side chain and for each Deposit calls SubmitSignatureMC on the side chain
-func ProcessSCDeposits(ctx context.Context, auth *bind.TransactOpts,
-	mc *mainchain.MainChain, sc *sidechain.SideChain,
+// relaySCDeposit submits the sealer's signature for a deposit made on the side chain,
+// unless the sealer already signed or the withdrawal was already executed
+func relaySCDeposit(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, mcClient HeadReader,
 	addr common.Address, key *ecdsa.PrivateKey,
-	dbPath string, start uint64, end *uint64, wg *sync.WaitGroup) {
-	i, _ := sc.FilterDeposit(&bind.FilterOpts{
-		Start:   start,
-		End:     end,
-		Context: ctx,
-	}, []common.Address{}, []common.Address{})
-	for i.Next() {
-		tx, err := SubmitSignatureMC(ctx, addr, auth, sc, i.Event, key)
-		log.Println("[sc2mc]", i.Event.Raw.BlockNumber, tx, err)
-		PersistLastBlock(dbPath, "SCDeposit", i.Event.Raw.BlockNumber)
-	}
-	wg.Done()
-}
-
-// ProcessSCSignatureAdded watches the side chain and for each SignatureAdded calls SubmitTransaction on the main chain
-func ProcessSCSignatureAdded(ctx context.Context, auth *bind.TransactOpts,
-	mc *mainchain.MainChain, sc *sidechain.SideChain,
-	dbPath string, start uint64, end *uint64, wg *sync.WaitGroup) {
-	i, _ := sc.FilterSignatureAdded(&bind.FilterOpts{
-		Start:   start,
-		End:     end,
-		Context: ctx,
+	store Store, scanner *LogScanner, event *sidechain.SideChainDeposit) (relayOutcome, error) {
+	logger := transferLogger(SideChainToMainChain, event.Raw.TxHash, event.Raw.BlockNumber)
+	retry := &RetryEntry{Kind: RetrySignature, SourceTx: event.Raw.TxHash, SourceBlock: event.Raw.BlockNumber, To: event.To, Value: event.Value}
+	t, err := observeTransfer(store, SideChainToMainChain, event.Raw, event.To, event.Value)
+	if err != nil {
+		logger.WithError(err).Error("can't record the deposit")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+
+	done, err := signedMC(ctx, mc, sc, mcClient, store, scanner, addr, sender.Auth.From, event.Raw.TxHash)
+	if err != nil {
+		logger.WithError(err).Error("can't check the signature")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	if done != "" {
+		logger.With(Fields{"state": done}).Info("skipped, already done")
+		skipTransfer(store, logger, t, done)
+		clearRetry(store, logger, retry)
+		return relaySkipped, nil
+	}
+
+	tx, err := sender.Transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return SubmitSignatureMC(ctx, addr, opts, sc, event, key)
 	})
-	for i.Next() {
-		enough, _ := HasEnoughSignaturesMC(ctx, sc, auth.From, i.Event.TxHash)
-		if enough {
-			resp, _ := sc.GetTransactionMC(&bind.CallOpts{Pending: false, From: auth.From, Context: ctx}, i.Event.TxHash)
-			tx, err := mc.SubmitTransaction(auth, i.Event.TxHash, resp.Destination, resp.Value, resp.Data, resp.V, resp.R, resp.S)
-			log.Println("[sc2mc]", i.Event.Raw.BlockNumber, tx, err)
-			PersistLastBlock(dbPath, "SCSignatureAdded", i.Event.Raw.BlockNumber)
+	logger = withTx(logger, tx)
+	saveTransfer(store, logger, t, TransferVoted, tx, err)
+	if err != nil {
+		logger.WithError(err).Error("can't sign")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	logger.Info("signed")
+	clearRetry(store, logger, retry)
+	return relaySent, nil
+}
+
+// signedMC returns TransferVoted if the signature of the sealer for the withdrawal of txHash
+// is already on the side chain, TransferExecuted if the withdrawal was executed on the main
+// chain, or an empty state otherwise
+func signedMC(ctx context.Context, mc MainChainBackend, sc SideChainBackend, mcClient HeadReader,
+	store Store, scanner *LogScanner, sideChainWalletAddress common.Address, sealerAddr common.Address, txHash common.Hash) (TransferState, error) {
+	signers, err := SignersMC(ctx, sc, sideChainWalletAddress, sealerAddr, txHash)
+	if err != nil {
+		return "", err
+	}
+	for _, signer := range signers {
+		if signer == sealerAddr {
+			return TransferVoted, nil
 		}
 	}
-	wg.Done()
+	executed, err := ExecutedMC(ctx, mcClient, mc, store, scanner, txHash)
+	if err != nil || executed {
+		return TransferExecuted, err
+	}
+	return "", nil
 }
 
-func handleFatal(err error) {
+// ProcessSCSignatureAdded watches the side chain and for each SignatureAdded calls SubmitTransaction on the main chain.
+// Like ProcessMCDeposits, it only returns the errors that stop the scan.
+func ProcessSCSignatureAdded(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, mcClient HeadReader, addr common.Address,
+	store Store, scanner *LogScanner, fallbackTimeout time.Duration, start uint64, end *uint64) error {
+	var stats RelayStats
+	defer func() { stats.log(SideChainToMainChain, "processed side chain signatures") }()
+
+	// The signatures added before start count too
+	if err := IndexSignatures(ctx, sc, store, scanner, &start); err != nil {
+		return err
+	}
+
+	cursor, err := GetCursor(store, "SCSignatureAdded")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	return scanner.Backfill(start, end, func(from uint64, to *uint64) (func() error, error) {
+		events, err := sc.SignaturesAdded(&bind.FilterOpts{
+			Start:   from,
+			End:     to,
+			Context: ctx,
+		})
+		if err != nil {
+			return nil, rpcError("filter signatures", err)
+		}
+		return func() error {
+			return processSignatureWindow(ctx, sender, mc, sc, mcClient, addr, store, scanner, fallbackTimeout, &stats, cursor, events, to)
+		}, nil
+	})
+}
+
+// processSignatureWindow indexes and relays the SignatureAdded events of a window of blocks
+func processSignatureWindow(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, mcClient HeadReader, addr common.Address,
+	store Store, scanner *LogScanner, fallbackTimeout time.Duration, stats *RelayStats, cursor *Cursor, events []*sidechain.SideChainSignatureAdded, to *uint64) error {
+	for _, event := range events {
+		if err := indexSignature(store, event); err != nil {
+			return err
+		}
+		if cursor.Handled(event.Raw) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		outcome, err := relaySCSignatureAdded(ctx, sender, mc, sc, mcClient, addr, store, scanner, fallbackTimeout, event)
+		stats.add(outcome)
+		if err != nil {
+			return err
+		}
+		if err := PersistCheckpoint(store, "SCSignatureAdded", logCheckpoint(event.Raw)); err != nil {
+			return err
+		}
+	}
+	if to != nil {
+		err := store.Update(func(tx StoreTx) error {
+			return advanceLastBlock(tx, signatureIndexBlock, *to)
+		})
+		if err != nil {
+			return storeError("advance signature index", err)
+		}
+	}
+	return checkpointWindow(store, "SCSignatureAdded", to)
+}
+
+// relaySCSignatureAdded submits the withdrawal on the main chain once enough signatures
+// have been collected on the side chain, unless it was already executed. A sealer that isn't
+// the designated submitter waits fallbackTimeout for each sealer before it in the rotation,
+// see SubmitterOrder.
+func relaySCSignatureAdded(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, mcClient HeadReader, addr common.Address,
+	store Store, scanner *LogScanner, fallbackTimeout time.Duration, event *sidechain.SideChainSignatureAdded) (relayOutcome, error) {
+	logger := transferLogger(SideChainToMainChain, event.TxHash, event.Raw.BlockNumber)
+	enough, err := HasEnoughSignaturesMC(ctx, sc, store, addr, sender.Auth.From, event.TxHash)
+	if err != nil {
+		logger.WithError(err).Error("can't count the signatures")
+		return relayFailed, scheduleRetry(store, logger, &RetryEntry{Kind: RetryWithdrawal, SourceTx: event.TxHash, SourceBlock: event.Raw.BlockNumber}, err)
+	}
+	if !enough {
+		return relayWaiting, nil
+	}
+	resp, err := sc.GetTransactionMC(&bind.CallOpts{Pending: false, From: sender.Auth.From, Context: ctx}, event.TxHash)
+	if err != nil {
+		err = rpcError("get withdrawal", err)
+		logger.WithError(err).Error("can't read the withdrawal")
+		return relayFailed, scheduleRetry(store, logger, &RetryEntry{Kind: RetryWithdrawal, SourceTx: event.TxHash, SourceBlock: event.Raw.BlockNumber}, err)
+	}
+	retry := &RetryEntry{Kind: RetryWithdrawal, SourceTx: event.TxHash, SourceBlock: event.Raw.BlockNumber, To: resp.Destination, Value: resp.Value}
+	// The deposit may have been made before this sealer started, the block it was mined in is unknown then
+	t, err := observeTransfer(store, SideChainToMainChain, types.Log{TxHash: event.TxHash}, resp.Destination, resp.Value)
+	if err != nil {
+		logger.WithError(err).Error("can't record the withdrawal")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	if t.State == TransferRemoved {
+		logger.Warn("skipped, the deposit was removed from the side chain")
+		clearRetry(store, logger, retry)
+		return relaySkipped, nil
+	}
+
+	executed, err := ExecutedMC(ctx, mcClient, mc, store, scanner, event.TxHash)
+	if err != nil {
+		logger.WithError(err).Error("can't check the execution")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	if executed {
+		logger.With(Fields{"state": TransferExecuted}).Info("skipped, already done")
+		skipTransfer(store, logger, t, TransferExecuted)
+		clearRetry(store, logger, retry)
+		return relaySkipped, nil
+	}
+
+	// Leave the withdrawal to the sealers before this one in the rotation, unless they
+	// didn't execute it in time
+	delay, err := submitterDelay(ctx, sc, sender.Auth.From, event.TxHash, fallbackTimeout)
+	if err != nil {
+		logger.WithError(err).Error("can't find the designated submitter")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	if delay > 0 {
+		if t.ReadyAt.IsZero() {
+			t.ReadyAt = time.Now()
+			if err := PutTransfer(store, t); err != nil {
+				logger.WithError(err).Error("can't record the transfer")
+			}
+		}
+		if time.Since(t.ReadyAt) < delay {
+			logger.With(Fields{"wait": (delay - time.Since(t.ReadyAt)).String()}).Debug("left to the designated submitter")
+			return relayWaiting, nil
+		}
+		logger.With(Fields{"delay": delay.String()}).Warn("not executed by the designated submitter, submitting as a fallback")
+	}
+
+	tx, err := sender.Transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return mc.SubmitTransaction(opts, event.TxHash, resp.Destination, resp.Value, resp.Data, resp.V, resp.R, resp.S)
+	})
+	logger = withTx(logger, tx)
+	saveTransfer(store, logger, t, TransferSubmitted, tx, err)
+	if err != nil {
+		logger.WithError(err).Error("can't submit the withdrawal")
+		return relayFailed, scheduleRetry(store, logger, retry, err)
+	}
+	logger.Info("submitted the withdrawal")
+	clearRetry(store, logger, retry)
+	return relaySent, nil
+}
+
+// storeReadWriter is the part common to a Store and a StoreTx
+type storeReadWriter interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+}
+
+// PersistLastBlock saves the last processed block to the store
+func PersistLastBlock(store Store, eventType string, blockNumber uint64) error {
+	return storeError("persist last block", putLastBlock(store, eventType, blockNumber))
+}
+
+func putLastBlock(s storeReadWriter, eventType string, blockNumber uint64) error {
+	return s.Put(eventType, []byte(strconv.FormatUint(blockNumber, 10)))
+}
+
+// advanceLastBlock saves blockNumber as the last processed block, unless it is behind
+func advanceLastBlock(s storeReadWriter, eventType string, blockNumber uint64) error {
+	last, err := getLastBlock(s, eventType)
+	if err != nil || last >= blockNumber {
+		return err
 	}
+	return putLastBlock(s, eventType, blockNumber)
 }
 
-// PersistLastBlock saves the last processed block to a file
-func PersistLastBlock(dbPath string, eventType string, blockNumber uint64) {
-	f, err := os.Create(dbPath + "/" + eventType)
-	handleFatal(err)
-	defer f.Close()
-	_, err = f.WriteString(strconv.FormatUint(blockNumber, 10))
-	handleFatal(err)
-	err = f.Sync()
-	handleFatal(err)
+// GetLastProcessedBlock returns the last processed block number from the store,
+// or 0 if none was saved yet
+func GetLastProcessedBlock(store Store, eventType string) (uint64, error) {
+	last, err := getLastBlock(store, eventType)
+	return last, storeError("get last processed block", err)
 }
 
-// GetLastProcessedBlock returns the last processed block number from a file
-func GetLastProcessedBlock(dbPath string, eventType string) uint64 {
-	// Create the file if it doesn't exist
-	f, err := os.OpenFile(dbPath+"/"+eventType, os.O_CREATE, os.ModePerm)
-	handleFatal(err)
-	f.Close()
-	// Read the whole file
-	c, err := ioutil.ReadFile(dbPath + "/" + eventType)
-	handleFatal(err)
-	if len(c) == 0 {
-		return 0
+func getLastBlock(s storeReadWriter, eventType string) (uint64, error) {
+	c, err := s.Get(eventType)
+	if err != nil || len(c) == 0 {
+		return 0, err
 	}
-	// Parse it to uint64
-	blockNumber, err := strconv.ParseUint(string(c[:]), 10, 64)
-	handleFatal(err)
-	return blockNumber
+	return strconv.ParseUint(string(c), 10, 64)
 }
 
 // EndBlock calculates the last block to process
@@ -21,6 +21,9 @@ package icn
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"errors"
+	"io/ioutil"
 	"math/big"
 	"os"
 	"reflect"
@@ -33,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -128,6 +132,165 @@ func TestSign(t *testing.T) {
 	}
 }
 
+func TestRecoverSigner(t *testing.T) {
+	key, _ := crypto.HexToECDSA("148435bc1bc5ee5ab6f57745625d6c3e15e99b335f29ba75a8542546fd2e2dc4")
+	msgHash := common.HexToHash("0x6b0673bcb3726c0f7956ef57a9542ed225bfe74f1d2a75414d198d55e8956da5")
+	v, r, s, _ := Sign(msgHash, key)
+
+	have, err := RecoverSigner(msgHash, v, r, s)
+	want := crypto.PubkeyToAddress(key.PublicKey)
+	if err != nil || have != want {
+		t.Errorf("RecoverSigner() = %v, %v, want %v", have.Hex(), err, want.Hex())
+	}
+
+	_, err = RecoverSigner(msgHash, 27, common.Hash{}, common.Hash{})
+	if err == nil || IsKind(err, ErrRPC) || IsKind(err, ErrStore) || IsKind(err, ErrRevert) {
+		t.Errorf("RecoverSigner() err = %v, want an error of no kind", err)
+	}
+}
+
+// withdrawalSideChain returns a withdrawal holding the given signatures
+type withdrawalSideChain struct {
+	SideChainBackend
+	sigs []Signature
+}
+
+func (f *withdrawalSideChain) GetTransactionMC(opts *bind.CallOpts, txHash [32]byte) (struct {
+	Destination common.Address
+	Value       *big.Int
+	Data        []byte
+	V           []uint8
+	R           [][32]byte
+	S           [][32]byte
+}, error) {
+	var resp struct {
+		Destination common.Address
+		Value       *big.Int
+		Data        []byte
+		V           []uint8
+		R           [][32]byte
+		S           [][32]byte
+	}
+	for _, sig := range f.sigs {
+		resp.V = append(resp.V, sig.V)
+		resp.R = append(resp.R, sig.R)
+		resp.S = append(resp.S, sig.S)
+	}
+	return resp, nil
+}
+
+func TestSignersMC(t *testing.T) {
+	wallet := common.HexToAddress("0x5c")
+	txHash := common.HexToHash("0x1")
+	msgHash := MsgHash(wallet, txHash, common.Address{}, nil, nil, 1)
+	owner1, _ := crypto.GenerateKey()
+	owner2, _ := crypto.GenerateKey()
+	sign := func(key *ecdsa.PrivateKey) Signature {
+		v, r, s, _ := Sign(msgHash, key)
+		return Signature{V: v, R: r, S: s}
+	}
+
+	// An invalid signature doesn't hide the ones after it
+	sc := &withdrawalSideChain{sigs: []Signature{sign(owner1), {V: 27, S: common.HexToHash("0x1")}, sign(owner2)}}
+	have, err := SignersMC(context.Background(), sc, wallet, common.Address{}, txHash)
+	want := []common.Address{crypto.PubkeyToAddress(owner1.PublicKey), crypto.PubkeyToAddress(owner2.PublicKey)}
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("have = %v, %v, want %v, %v", have, err, want, nil)
+	}
+}
+
+// sendingSideChain records the transactions sent to a side chain wallet, and fails them
+type sendingSideChain struct {
+	SideChainBackend
+	sent int
+}
+
+func (f *sendingSideChain) SubmitTransactionSC(opts *bind.TransactOpts, txHash [32]byte, destination common.Address,
+	value *big.Int, data []byte) (*types.Transaction, error) {
+	f.sent++
+	return nil, errors.New("not sent")
+}
+
+func (f *sendingSideChain) SubmitSignatureMC(opts *bind.TransactOpts, txHash [32]byte, destination common.Address,
+	value *big.Int, data []byte, v uint8, r [32]byte, s [32]byte) (*types.Transaction, error) {
+	f.sent++
+	return nil, errors.New("not sent")
+}
+
+func TestRelayMCDepositVoted(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		sealer := common.HexToAddress("0x5e")
+		sender := &Sender{Auth: &bind.TransactOpts{From: sealer}, Gas: GasPolicy{Mode: GasZero}}
+		sc := &sendingSideChain{SideChainBackend: &traceSideChain{confirmed: map[common.Address]bool{sealer: true}}}
+		event := &mainchain.MainChainDeposit{To: common.HexToAddress("0x70"), Value: big.NewInt(1), Raw: types.Log{TxHash: common.HexToHash("0x1"), BlockNumber: 3}}
+
+		// The sealer voted before it lost its records
+		outcome, err := relayMCDeposit(context.Background(), sender, sc, store, event)
+		if err != nil || outcome != relaySkipped || sc.sent != 0 {
+			t.Errorf("have = %v, %v, %v sent, want %v, %v, %v sent", outcome, err, sc.sent, relaySkipped, nil, 0)
+		}
+		if have, _ := GetTransfer(store, event.Raw.TxHash); have == nil || have.State != TransferVoted {
+			t.Errorf("have = %v, want %v", have, TransferVoted)
+		}
+	})
+}
+
+func TestRelaySCDepositSigned(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		wallet := common.HexToAddress("0x5c")
+		key, _ := crypto.GenerateKey()
+		sealer := crypto.PubkeyToAddress(key.PublicKey)
+		sender := &Sender{Auth: &bind.TransactOpts{From: sealer}, Gas: GasPolicy{Mode: GasZero}}
+		event := &sidechain.SideChainDeposit{Raw: types.Log{TxHash: common.HexToHash("0x1"), BlockNumber: 3}}
+		v, r, s, _ := Sign(MsgHash(wallet, event.Raw.TxHash, common.Address{}, nil, nil, 1), key)
+		sc := &sendingSideChain{SideChainBackend: &withdrawalSideChain{sigs: []Signature{{V: v, R: r, S: s}}}}
+
+		// The signature of the sealer is already stored in the withdrawal
+		outcome, err := relaySCDeposit(context.Background(), sender, nil, sc, nil, wallet, key, store, nil, event)
+		if err != nil || outcome != relaySkipped || sc.sent != 0 {
+			t.Errorf("have = %v, %v, %v sent, want %v, %v, %v sent", outcome, err, sc.sent, relaySkipped, nil, 0)
+		}
+		if have, _ := GetTransfer(store, event.Raw.TxHash); have == nil || have.State != TransferVoted {
+			t.Errorf("have = %v, want %v", have, TransferVoted)
+		}
+	})
+}
+
+func TestCountOwners(t *testing.T) {
+	msgHash := common.HexToHash("0x6b0673bcb3726c0f7956ef57a9542ed225bfe74f1d2a75414d198d55e8956da5")
+	owner1, _ := crypto.GenerateKey()
+	owner2, _ := crypto.GenerateKey()
+	stranger, _ := crypto.GenerateKey()
+	owners := map[common.Address]bool{
+		crypto.PubkeyToAddress(owner1.PublicKey): true,
+		crypto.PubkeyToAddress(owner2.PublicKey): true,
+	}
+	isOwner := func(addr common.Address) (bool, error) { return owners[addr], nil }
+	sign := func(key *ecdsa.PrivateKey, index uint) Signature {
+		v, r, s, _ := Sign(msgHash, key)
+		return Signature{V: v, R: r, S: s, Index: index}
+	}
+
+	tests := []struct {
+		name string
+		sigs []Signature
+		want int
+	}{
+		{"Counts each owner", []Signature{sign(owner1, 0), sign(owner2, 1)}, 2},
+		{"Counts an owner who signed twice once", []Signature{sign(owner1, 0), sign(owner1, 1)}, 1},
+		{"Ignores the signatures of other accounts", []Signature{sign(owner1, 0), sign(stranger, 1)}, 1},
+		{"Ignores invalid signatures", []Signature{sign(owner1, 0), {V: 27, Index: 1}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have, err := countOwners(msgHash, tt.sigs, isOwner)
+			if err != nil || have != tt.want {
+				t.Errorf("have = %v, %v, want %v, %v", have, err, tt.want, nil)
+			}
+		})
+	}
+}
+
 func TestEndBlock(t *testing.T) {
 	var sum uint64 = 130
 	type args struct {
@@ -192,17 +355,27 @@ func TestMainChainToSideChain(t *testing.T) {
 		tester2.From: core.GenesisAccount{Balance: big.NewInt(10000000000)},
 	})
 
-	_, _, sc, _ := sidechain.DeploySideChain(sealer1, scClient, []common.Address{sealer1.From, sealer2.From}, 2)
-	_, _, mc, _ := mainchain.DeployMainChain(sealer2, mcClient, []common.Address{sealer1.From, sealer2.From}, 2)
+	_, _, scBinding, _ := sidechain.DeploySideChain(sealer1, scClient, []common.Address{sealer1.From, sealer2.From}, 2)
+	_, _, mcBinding, _ := mainchain.DeployMainChain(sealer2, mcClient, []common.Address{sealer1.From, sealer2.From}, 2)
+	sc, mc := SideChainBinding{scBinding}, MainChainBinding{mcBinding}
 
 	tester2.Value = big.NewInt(200000000)
 	tx, _ := mc.Deposit(tester2, tester1.From)
 	mcClient.Commit()
 
+	// Each sealer runs its own node, with its own records and cursors
+	store1, closeStore1 := tempStore(t)
+	defer closeStore1()
+	store2, closeStore2 := tempStore(t)
+	defer closeStore2()
+
 	var wg sync.WaitGroup
-	wg.Add(2)
-	go ProcessMCDeposits(ctx, sealer1Auth, mc, sc, os.TempDir(), 0, nil, &wg)
-	go ProcessMCDeposits(ctx, sealer2Auth, mc, sc, os.TempDir(), 0, nil, &wg)
+	inBackground(t, &wg, func() error {
+		return ProcessMCDeposits(ctx, &Sender{Auth: sealer1Auth, Client: scClient}, mc, sc, store1, nil, 0, nil)
+	})
+	inBackground(t, &wg, func() error {
+		return ProcessMCDeposits(ctx, &Sender{Auth: sealer2Auth, Client: scClient}, mc, sc, store2, nil, 0, nil)
+	})
 	wg.Wait()
 	scClient.Commit()
 
@@ -217,6 +390,20 @@ func TestMainChainToSideChain(t *testing.T) {
 		}
 	})
 
+	t.Run("Votes are not sent again when the deposits are processed again", func(t *testing.T) {
+		// A node that lost its records reads the deposit again, only the chain tells it was voted
+		store, closeStore := tempStore(t)
+		defer closeStore()
+		if err := ProcessMCDeposits(ctx, &Sender{Auth: sealer1Auth, Client: scClient}, mc, sc, store, nil, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		pending, _ := scClient.PendingNonceAt(ctx, sealer1.From)
+		mined, _ := scClient.NonceAt(ctx, sealer1.From, nil)
+		if pending != mined {
+			t.Errorf("pending nonce = %v, want %v", pending, mined)
+		}
+	})
+
 	t.Run("Sender has been debited on the mainchain", func(t *testing.T) {
 		have, _ := mcClient.BalanceAt(ctx, tester2.From, nil)
 		want := big.NewInt(10000000000 - 200000000 - int64(tx.Gas()))
@@ -283,31 +470,44 @@ func TestSideChainToMainChain(t *testing.T) {
 		tester2.From: core.GenesisAccount{Balance: big.NewInt(10000000000)},
 	})
 
-	_, _, sc, _ := sidechain.DeploySideChain(sealer1, scClient, []common.Address{sealer1.From, sealer2.From}, 2)
-	_, _, mc, _ := mainchain.DeployMainChain(sealer2, mcClient, []common.Address{sealer1.From, sealer2.From}, 2)
+	_, _, scBinding, _ := sidechain.DeploySideChain(sealer1, scClient, []common.Address{sealer1.From, sealer2.From}, 2)
+	_, _, mcBinding, _ := mainchain.DeployMainChain(sealer2, mcClient, []common.Address{sealer1.From, sealer2.From}, 2)
+	sc, mc := SideChainBinding{scBinding}, MainChainBinding{mcBinding}
+	// The simulated backend can't return its headers, the main chain has a single block
+	mcHead := &fakeChain{headers: map[uint64]*types.Header{1: {Number: big.NewInt(1)}}}
 
 	tester1.Value = big.NewInt(200000000)
 	tx, _ := sc.Deposit(tester1, tester2.From)
 	scClient.Commit()
 
+	// Each sealer runs its own node, with its own records and cursors
+	store1, closeStore1 := tempStore(t)
+	defer closeStore1()
+	store2, closeStore2 := tempStore(t)
+	defer closeStore2()
+
 	var wg sync.WaitGroup
-	wg.Add(2)
-	go ProcessSCDeposits(ctx, sealer1Auth, mc, sc, scAddr, sealer1Key, os.TempDir(), 0, nil, &wg)
-	go ProcessSCDeposits(ctx, sealer2Auth, mc, sc, scAddr, sealer2Key, os.TempDir(), 0, nil, &wg)
+	inBackground(t, &wg, func() error {
+		return ProcessSCDeposits(ctx, &Sender{Auth: sealer1Auth, Client: scClient}, mc, sc, mcHead, scAddr, sealer1Key, store1, nil, 0, nil)
+	})
+	inBackground(t, &wg, func() error {
+		return ProcessSCDeposits(ctx, &Sender{Auth: sealer2Auth, Client: scClient}, mc, sc, mcHead, scAddr, sealer2Key, store2, nil, 0, nil)
+	})
 	wg.Wait()
 	scClient.Commit()
 
 	t.Run("HasEnoughSignaturesMC", func(t *testing.T) {
-		have, _ := HasEnoughSignaturesMC(ctx, sc, sealer1.From, tx.Hash())
+		IndexSignatures(ctx, sc, store1, nil, nil)
+		have, _ := HasEnoughSignaturesMC(ctx, sc, store1, scAddr, sealer1.From, tx.Hash())
 		want := true
 		if have != want {
 			t.Errorf("have = %v, want %v", have, want)
 		}
 	})
 
-	wg.Add(1)
-	go ProcessSCSignatureAdded(ctx, sealer1Auth, mc, sc, os.TempDir(), 0, nil, &wg)
-	wg.Wait()
+	if err := ProcessSCSignatureAdded(ctx, &Sender{Auth: sealer1Auth, Client: mcClient}, mc, sc, mcHead, scAddr, store1, nil, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
 	mcClient.Commit()
 
 	t.Run("Sender has been debited on the sidechain", func(t *testing.T) {
@@ -342,3 +542,31 @@ func TestSideChainToMainChain(t *testing.T) {
 		}
 	})
 }
+
+// tempStore opens a file store in a temporary directory, removed by the returned function
+func tempStore(t *testing.T) (Store, func()) {
+	dir, err := ioutil.TempDir("", "icn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// inBackground runs process in a goroutine of wg, reporting its error
+func inBackground(t *testing.T, wg *sync.WaitGroup, process func() error) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := process(); err != nil {
+			t.Error(err)
+		}
+	}()
+}
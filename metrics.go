@@ -0,0 +1,179 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics of the node, registered with the default prometheus registry. They are served
+// by promhttp.Handler, see the --httpaddr flag of icn.
+var (
+	depositsObserved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "icn",
+		Name:      "deposits_observed_total",
+		Help:      "Deposits seen with enough confirmations on the source chain.",
+	}, []string{"direction"})
+	submissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "icn",
+		Name:      "submissions_total",
+		Help:      "Votes, signatures and withdrawals sent by the sealer.",
+	}, []string{"direction", "kind"})
+	transfersExecuted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "icn",
+		Name:      "transfers_executed_total",
+		Help:      "Transfers found executed on the destination chain.",
+	}, []string{"direction"})
+	submissionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "icn",
+		Name:      "submission_errors_total",
+		Help:      "Transactions of the sealer that couldn't be sent or reverted, by cause: rpc, store, revert or other.",
+	}, []string{"direction", "category"})
+	checkpointLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "icn",
+		Name:      "checkpoint_lag_blocks",
+		Help:      "Blocks between the head of the chain and the last block processed, for each kind of event.",
+	}, []string{"event"})
+	gasUsed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "icn",
+		Name:      "gas_used_total",
+		Help:      "Gas used by the mined transactions of the sealer.",
+	}, []string{"chain"})
+	transferLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "icn",
+		Name:      "transfer_latency_seconds",
+		Help:      "Time from a deposit seen by the node to the transfer found executed.",
+		Buckets:   prometheus.ExponentialBuckets(5, 2, 12),
+	}, []string{"direction"})
+)
+
+func init() {
+	prometheus.MustRegister(depositsObserved, submissions, transfersExecuted, submissionErrors,
+		checkpointLag, gasUsed, transferLatency)
+}
+
+// Chains, as labelled in the metrics
+const (
+	mainChainLabel = "mainchain"
+	sideChainLabel = "sidechain"
+)
+
+// eventChains gives the chain of each kind of event
+var eventChains = map[string]string{
+	"MCDeposit":        mainChainLabel,
+	"SCDeposit":        sideChainLabel,
+	"SCSignatureAdded": sideChainLabel,
+}
+
+// observation is a step of a transfer waiting to be counted in the metrics
+type observation struct {
+	// index is the position of the step in the steps of the transfer
+	index int
+	err   error
+}
+
+// observeSteps updates the metrics for the steps reached by a transfer since it was last
+// saved. It is called once the record is saved, so that the steps lost with a failed write
+// are not counted.
+func observeSteps(t *Transfer) {
+	for _, o := range t.unobserved {
+		observeStep(t, o.index, o.err)
+	}
+	t.unobserved = nil
+}
+
+// observeStep updates the metrics for the step i of a transfer. A deposit confirmed again
+// after a reorganization isn't counted twice, while each transaction sent and each failure is.
+func observeStep(t *Transfer, i int, err error) {
+	step := t.Steps[i]
+	switch step.State {
+	case TransferConfirmed:
+		for _, before := range t.Steps[:i] {
+			if before.State == TransferConfirmed {
+				return
+			}
+		}
+		depositsObserved.WithLabelValues(t.Direction).Inc()
+	case TransferVoted, TransferSubmitted:
+		if step.TxHash != (common.Hash{}) {
+			submissions.WithLabelValues(t.Direction, submissionKind(t.Direction, step.State)).Inc()
+		}
+	case TransferExecuted:
+		transfersExecuted.WithLabelValues(t.Direction).Inc()
+		if len(t.Steps) > 0 {
+			transferLatency.WithLabelValues(t.Direction).Observe(step.Time.Sub(t.Steps[0].Time).Seconds())
+		}
+	case TransferFailed:
+		submissionErrors.WithLabelValues(t.Direction, errorCategory(err)).Inc()
+	}
+}
+
+// submissionKind names the transaction sent for a transfer in state
+func submissionKind(direction string, state TransferState) string {
+	switch {
+	case state == TransferSubmitted:
+		return "withdrawal"
+	case direction == MainChainToSideChain:
+		return "vote"
+	}
+	return "signature"
+}
+
+// errorCategory returns the kind of err, as labelled in the metrics
+func errorCategory(err error) string {
+	switch {
+	case IsKind(err, ErrRPC):
+		return "rpc"
+	case IsKind(err, ErrStore):
+		return "store"
+	case IsKind(err, ErrRevert):
+		return "revert"
+	}
+	return "other"
+}
+
+// observeLag updates the lag of each kind of event behind the head of its chain
+func observeLag(ctx context.Context, heads map[string]HeadReader, store Store, eventTypes []string) error {
+	for _, eventType := range eventTypes {
+		lag, err := eventLag(ctx, heads[eventChains[eventType]], store, eventType)
+		if err != nil {
+			return err
+		}
+		checkpointLag.WithLabelValues(eventType).Set(float64(lag))
+	}
+	return nil
+}
+
+// eventLag returns the number of blocks between the head of the chain and the last block
+// processed for eventType
+func eventLag(ctx context.Context, client HeadReader, store Store, eventType string) (uint64, error) {
+	head, err := headNumber(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	last, err := GetLastProcessedBlock(store, eventType)
+	if err != nil || head <= last {
+		return 0, err
+	}
+	return head - last, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// readOnlyStore fails every write
+type readOnlyStore struct {
+	Store
+}
+
+func (s readOnlyStore) Put(key string, value []byte) error {
+	return errors.New("read-only file system")
+}
+
+func TestTransferMetrics(t *testing.T) {
+	counter := func(name string) float64 {
+		switch name {
+		case "observed":
+			return testutil.ToFloat64(depositsObserved.WithLabelValues(SideChainToMainChain))
+		case "signatures":
+			return testutil.ToFloat64(submissions.WithLabelValues(SideChainToMainChain, "signature"))
+		case "withdrawals":
+			return testutil.ToFloat64(submissions.WithLabelValues(SideChainToMainChain, "withdrawal"))
+		case "executed":
+			return testutil.ToFloat64(transfersExecuted.WithLabelValues(SideChainToMainChain))
+		case "reverted":
+			return testutil.ToFloat64(submissionErrors.WithLabelValues(SideChainToMainChain, "revert"))
+		}
+		return 0
+	}
+	names := []string{"observed", "signatures", "withdrawals", "executed", "reverted"}
+	// check runs steps and compares the counters before and after it with want
+	check := func(t *testing.T, want map[string]float64, steps func()) {
+		before := map[string]float64{}
+		for _, name := range names {
+			before[name] = counter(name)
+		}
+		steps()
+		for _, name := range names {
+			if have := counter(name) - before[name]; have != want[name] {
+				t.Errorf("%s: have = %v, want %v", name, have, want[name])
+			}
+		}
+	}
+	newTransfer := func() *Transfer {
+		return &Transfer{Direction: SideChainToMainChain, State: TransferObserved, Steps: []TransferStep{{State: TransferObserved}}}
+	}
+
+	testStores(t, func(t *testing.T, store Store) {
+		t.Run("Counts the steps once saved", func(t *testing.T) {
+			want := map[string]float64{"observed": 1, "signatures": 1, "withdrawals": 2, "executed": 1, "reverted": 1}
+			check(t, want, func() {
+				tr := newTransfer()
+				tr.Advance(TransferConfirmed, common.Hash{}, nil)
+				tr.Advance(TransferVoted, common.HexToHash("0x1"), nil)
+				PutTransfer(store, tr)
+				tr.Advance(TransferSubmitted, common.HexToHash("0x2"), nil)
+				tr.Advance(TransferFailed, common.Hash{}, txError("send transaction", errors.New("execution reverted")))
+				tr.Advance(TransferSubmitted, common.HexToHash("0x3"), nil)
+				tr.Advance(TransferExecuted, common.Hash{}, nil)
+				PutTransfer(store, tr)
+				// Saving again counts nothing more
+				PutTransfer(store, tr)
+			})
+		})
+
+		t.Run("Doesn't count the steps that couldn't be saved", func(t *testing.T) {
+			check(t, map[string]float64{}, func() {
+				tr := newTransfer()
+				tr.Advance(TransferConfirmed, common.Hash{}, nil)
+				PutTransfer(readOnlyStore{store}, tr)
+			})
+		})
+
+		t.Run("Counts a deposit confirmed again after a reorganization once", func(t *testing.T) {
+			check(t, map[string]float64{"observed": 1}, func() {
+				tr := newTransfer()
+				tr.Advance(TransferConfirmed, common.Hash{}, nil)
+				tr.Advance(TransferRemoved, common.Hash{}, nil)
+				PutTransfer(store, tr)
+				tr.Advance(TransferConfirmed, common.Hash{}, nil)
+				PutTransfer(store, tr)
+			})
+		})
+	})
+}
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{rpcError("get head", errors.New("connection refused")), "rpc"},
+		{storeError("put transfer", errors.New("disk full")), "store"},
+		{ErrRevert, "revert"},
+		{context.DeadlineExceeded, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if have := errorCategory(tt.err); have != tt.want {
+				t.Errorf("have = %v, want %v", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveLag(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		PersistLastBlock(store, "MCDeposit", 90)
+		PersistLastBlock(store, "SCDeposit", 120)
+		heads := map[string]HeadReader{
+			mainChainLabel: &fakeChain{heads: []int64{100}, cancel: func() {}},
+			sideChainLabel: &fakeChain{heads: []int64{120}, cancel: func() {}},
+		}
+		if err := observeLag(context.Background(), heads, store, []string{"MCDeposit", "SCDeposit"}); err != nil {
+			t.Fatal(err)
+		}
+		for event, want := range map[string]float64{"MCDeposit": 10, "SCDeposit": 0} {
+			if have := testutil.ToFloat64(checkpointLag.WithLabelValues(event)); have != want {
+				t.Errorf("%s: have = %v, want %v", event, have, want)
+			}
+		}
+	})
+}
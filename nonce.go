@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// NonceReader returns the next nonce of an account, counting the pending transactions,
+// like ethclient.Client
+type NonceReader interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// NonceManager hands out the nonces of one account on one chain. The processors share it,
+// so that the transactions they send at the same time never get the same nonce.
+type NonceManager struct {
+	client NonceReader
+	from   common.Address
+
+	mu     sync.Mutex
+	next   uint64
+	synced bool
+}
+
+// NewNonceManager creates a NonceManager for the account from. It syncs with the chain
+// before handing out the first nonce.
+func NewNonceManager(client NonceReader, from common.Address) *NonceManager {
+	return &NonceManager{client: client, from: from}
+}
+
+// Sync sets the next nonce to the pending nonce of the account on the chain. The nonces of
+// transactions dropped by the node are handed out again, filling the gap they left.
+func (m *NonceManager) Sync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sync(ctx)
+}
+
+func (m *NonceManager) sync(ctx context.Context) error {
+	pending, err := m.client.PendingNonceAt(ctx, m.from)
+	if err != nil {
+		m.synced = false
+		return rpcError("pending nonce", err)
+	}
+	if m.synced && pending != m.next {
+		componentLogger("nonce").With(Fields{"account": m.from, "next": m.next, "pending": pending}).Warn("nonce resynced")
+	}
+	m.next = pending
+	m.synced = true
+	return nil
+}
+
+// Send calls send with the next nonce. Transactions are sent one at a time, and the nonce
+// is only used up if send succeeds.
+func (m *NonceManager) Send(ctx context.Context, send func(nonce uint64) (*types.Transaction, error)) (*types.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.synced {
+		if err := m.sync(ctx); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := send(m.next)
+	if err != nil {
+		// The nonce may have been used by another node sharing the account
+		m.synced = false
+		return nil, err
+	}
+	m.next++
+	return tx, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type fakeNonces struct {
+	pending uint64
+	calls   int
+}
+
+func (f *fakeNonces) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	f.calls++
+	return f.pending, nil
+}
+
+func TestNonceManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Concurrent transactions get consecutive nonces", func(t *testing.T) {
+		m := NewNonceManager(&fakeNonces{pending: 7}, common.Address{})
+		var mu sync.Mutex
+		var nonces []int
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.Send(ctx, func(nonce uint64) (*types.Transaction, error) {
+					mu.Lock()
+					nonces = append(nonces, int(nonce))
+					mu.Unlock()
+					return nil, nil
+				})
+			}()
+		}
+		wg.Wait()
+		sort.Ints(nonces)
+		for i, nonce := range nonces {
+			if nonce != 7+i {
+				t.Fatalf("have = %v, want 7 to 16", nonces)
+			}
+		}
+	})
+
+	t.Run("A failed transaction doesn't use up its nonce", func(t *testing.T) {
+		client := &fakeNonces{pending: 3}
+		m := NewNonceManager(client, common.Address{})
+		m.Send(ctx, func(nonce uint64) (*types.Transaction, error) {
+			return nil, errors.New("insufficient funds for gas * price + value")
+		})
+		var have uint64
+		m.Send(ctx, func(nonce uint64) (*types.Transaction, error) {
+			have = nonce
+			return nil, nil
+		})
+		if have != 3 || client.calls != 2 {
+			t.Errorf("have = %v after %d syncs, want %v after %d syncs", have, client.calls, 3, 2)
+		}
+	})
+
+	t.Run("Sync fills the gap left by dropped transactions", func(t *testing.T) {
+		client := &fakeNonces{pending: 5}
+		m := NewNonceManager(client, common.Address{})
+		for i := 0; i < 3; i++ {
+			m.Send(ctx, func(nonce uint64) (*types.Transaction, error) { return nil, nil })
+		}
+		client.pending = 6
+		if err := m.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var have uint64
+		m.Send(ctx, func(nonce uint64) (*types.Transaction, error) {
+			have = nonce
+			return nil, nil
+		})
+		if have != 6 {
+			t.Errorf("have = %v, want %v", have, 6)
+		}
+	})
+}
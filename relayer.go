@@ -0,0 +1,509 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Client is what a Relayer needs from the endpoint of a chain, like ethclient.Client
+type Client interface {
+	bind.ContractBackend
+	PendingReader
+	BalanceReader
+}
+
+// Config is what a Relayer can't do without: the endpoints and the wallets of both chains,
+// the key of the sealer, and where to keep its state
+type Config struct {
+	MainChainClient Client
+	SideChainClient Client
+	MainChainWallet common.Address
+	SideChainWallet common.Address
+	Key             *ecdsa.PrivateKey
+	Store           Store
+}
+
+// Option changes the default behavior of a Relayer
+type Option func(r *Relayer)
+
+// WithChains sets the chains whose deposits are relayed. Both are by default.
+func WithChains(mainChain bool, sideChain bool) Option {
+	return func(r *Relayer) {
+		r.mainChain, r.sideChain = mainChain, sideChain
+	}
+}
+
+// WithWatch keeps the relayer running and relaying new events until it is stopped.
+// interval is how often the chains are polled when their endpoints don't support subscriptions.
+func WithWatch(interval time.Duration) Option {
+	return func(r *Relayer) {
+		r.watch = true
+		r.pollInterval = interval
+	}
+}
+
+// WithBlocks limits the number of blocks processed by a relayer that doesn't watch the chains
+func WithBlocks(n uint64) Option {
+	return func(r *Relayer) {
+		r.nblocks = n
+	}
+}
+
+// WithConfirmations sets the number of blocks to wait on each chain before relaying an event
+func WithConfirmations(mainChain uint64, sideChain uint64) Option {
+	return func(r *Relayer) {
+		r.mcConfirmations, r.scConfirmations = mainChain, sideChain
+	}
+}
+
+// WithGas sets the gas price policy of each chain
+func WithGas(mainChain GasPolicy, sideChain GasPolicy) Option {
+	return func(r *Relayer) {
+		r.mcGas, r.scGas = mainChain, sideChain
+	}
+}
+
+// WithRetryPolicy sets how failed submissions are sent again
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(r *Relayer) {
+		r.retry = policy
+	}
+}
+
+// WithStuckBlocks sets the number of blocks after which a pending transaction is replaced.
+// They are never replaced if blocks is 0.
+func WithStuckBlocks(blocks uint64) Option {
+	return func(r *Relayer) {
+		r.stuckBlocks = blocks
+	}
+}
+
+// WithFallbackTimeout sets how long the sealer waits for each sealer before it in the
+// rotation to execute a withdrawal, see SubmitterOrder
+func WithFallbackTimeout(timeout time.Duration) Option {
+	return func(r *Relayer) {
+		r.fallbackTimeout = timeout
+	}
+}
+
+// WithShutdownTimeout sets how long the transactions being sent when the relayer is
+// stopped may take to be sent and recorded, see Sender.Grace
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(r *Relayer) {
+		r.shutdownTimeout = timeout
+	}
+}
+
+// WithHealth sets the thresholds of Ready: the number of blocks, on top of the confirmations,
+// that the events may be processed behind the head of their chain, 0 for no limit, and the
+// lowest balance (wei) of the sealer on each chain, nil for no minimum
+func WithHealth(maxLag uint64, mainChainBalance *big.Int, sideChainBalance *big.Int) Option {
+	return func(r *Relayer) {
+		r.maxLag = maxLag
+		r.mcMinBalance, r.scMinBalance = mainChainBalance, sideChainBalance
+	}
+}
+
+// WithScanner sets how past events are read
+func WithScanner(scanner *LogScanner) Option {
+	return func(r *Relayer) {
+		r.scanner = scanner
+	}
+}
+
+// WithBackends replaces the bindings of the wallets, for instance with fakes in tests
+func WithBackends(mainChain MainChainBackend, sideChain SideChainBackend) Option {
+	return func(r *Relayer) {
+		r.mc, r.sc = mainChain, sideChain
+	}
+}
+
+// Relayer relays the transfers between the main chain and the side chain for a sealer.
+// It runs in its own goroutines from Start until its work is done, or until it is stopped
+// if it watches the chains.
+type Relayer struct {
+	config   Config
+	sealer   common.Address
+	mc       MainChainBackend
+	sc       SideChainBackend
+	mcSender *Sender
+	scSender *Sender
+
+	mainChain       bool
+	sideChain       bool
+	watch           bool
+	pollInterval    time.Duration
+	nblocks         uint64
+	mcConfirmations uint64
+	scConfirmations uint64
+	mcGas           GasPolicy
+	scGas           GasPolicy
+	retry           RetryPolicy
+	stuckBlocks     uint64
+	fallbackTimeout time.Duration
+	shutdownTimeout time.Duration
+	scanner         *LogScanner
+	maxLag          uint64
+	mcMinBalance    *big.Int
+	scMinBalance    *big.Int
+
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	cancel    context.CancelFunc
+	done      chan struct{}
+	running   bool
+	stopped   bool
+	startedAt time.Time
+	err       error
+}
+
+// NewRelayer creates a Relayer from config, with the defaults of the icn command
+// changed by opts
+func NewRelayer(config Config, opts ...Option) (*Relayer, error) {
+	if config.MainChainClient == nil || config.SideChainClient == nil {
+		return nil, errors.New("relayer: the clients of both chains are required")
+	}
+	if config.Key == nil {
+		return nil, errors.New("relayer: the key of the sealer is required")
+	}
+	if config.Store == nil {
+		return nil, errors.New("relayer: a store is required")
+	}
+	r := &Relayer{
+		config:          config,
+		mainChain:       true,
+		sideChain:       true,
+		pollInterval:    15 * time.Second,
+		retry:           RetryPolicy{MaxAttempts: 5, InitialDelay: time.Minute, MaxDelay: time.Hour},
+		stuckBlocks:     20,
+		fallbackTimeout: 5 * time.Minute,
+		shutdownTimeout: 30 * time.Second,
+		maxLag:          100,
+		scanner:         &LogScanner{Window: 5000, Workers: 4},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.mc == nil {
+		mc, err := mainchain.NewMainChain(config.MainChainWallet, config.MainChainClient)
+		if err != nil {
+			return nil, err
+		}
+		r.mc = MainChainBinding{mc}
+	}
+	if r.sc == nil {
+		sc, err := sidechain.NewSideChain(config.SideChainWallet, config.SideChainClient)
+		if err != nil {
+			return nil, err
+		}
+		r.sc = SideChainBinding{sc}
+	}
+	auth := bind.NewKeyedTransactor(config.Key)
+	r.sealer = auth.From
+	r.mcSender = &Sender{
+		Auth:   auth,
+		Client: config.MainChainClient,
+		Gas:    r.mcGas,
+		Nonces: NewNonceManager(config.MainChainClient, auth.From),
+		Grace:  r.shutdownTimeout,
+	}
+	r.scSender = &Sender{
+		Auth:   auth,
+		Client: config.SideChainClient,
+		Gas:    r.scGas,
+		Nonces: NewNonceManager(config.SideChainClient, auth.From),
+		Grace:  r.shutdownTimeout,
+	}
+	return r, nil
+}
+
+// Start syncs the nonces of the sealer with the chains, then starts relaying in the
+// background. The relayer stops when ctx is cancelled, or when Stop is called: it
+// finishes sending the transactions in flight, records them and then returns.
+func (r *Relayer) Start(ctx context.Context) error {
+	if r.isRunning() {
+		return errors.New("relayer: already started")
+	}
+	// The chains are called without the lock, so that Status answers in the meantime
+	for _, sender := range []*Sender{r.mcSender, r.scSender} {
+		if err := sender.SyncNonce(ctx); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return errors.New("relayer: already started")
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+	r.running, r.stopped, r.err = true, false, nil
+	r.startedAt = time.Now()
+	if r.watch {
+		r.startWatching(ctx)
+	} else {
+		r.spawn(func() error { return r.runOnce(ctx) })
+	}
+
+	go func(cancel context.CancelFunc, done chan struct{}) {
+		r.wg.Wait()
+		cancel()
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+		close(done)
+	}(r.cancel, r.done)
+	return nil
+}
+
+func (r *Relayer) isRunning() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// Stop asks the relayer to stop, and waits until it did
+func (r *Relayer) Stop() {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.stopped = true
+		r.cancel()
+	}
+	r.mu.Unlock()
+	r.Wait()
+}
+
+// Wait waits until the relayer is done, and returns the first error that stopped a part of it
+func (r *Relayer) Wait() error {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Status is a snapshot of the state of a Relayer
+type Status struct {
+	Running   bool
+	Watching  bool
+	StartedAt time.Time
+	Sealer    common.Address
+	// Blocks is the next block to process for each kind of event relayed
+	Blocks map[string]uint64
+	// Err is the first error that stopped a part of the relayer
+	Err error
+}
+
+// Status returns the state of the relayer and how far it went on each chain
+func (r *Relayer) Status() (Status, error) {
+	r.mu.Lock()
+	status := Status{
+		Running:   r.running,
+		Watching:  r.watch,
+		StartedAt: r.startedAt,
+		Sealer:    r.sealer,
+		Blocks:    map[string]uint64{},
+		Err:       r.err,
+	}
+	r.mu.Unlock()
+	for _, eventType := range r.eventTypes() {
+		block, err := ResumeBlock(r.config.Store, eventType)
+		if err != nil {
+			return status, err
+		}
+		status.Blocks[eventType] = block
+	}
+	return status, nil
+}
+
+// eventTypes returns the kinds of events relayed
+func (r *Relayer) eventTypes() []string {
+	var eventTypes []string
+	if r.mainChain {
+		eventTypes = append(eventTypes, "MCDeposit")
+	}
+	if r.sideChain {
+		eventTypes = append(eventTypes, "SCDeposit", "SCSignatureAdded")
+	}
+	return eventTypes
+}
+
+// spawn runs fn in a goroutine of the relayer, recording its error
+func (r *Relayer) spawn(fn func() error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := fn(); err != nil {
+			r.fail(err)
+		}
+	}()
+}
+
+// fail records the first error of the relayer. Errors caused by Stop, or by the
+// cancellation of the context of Start, are ignored.
+func (r *Relayer) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil && !r.stopped && !IsKind(err, context.Canceled) {
+		r.err = err
+	}
+}
+
+func (r *Relayer) startWatching(ctx context.Context) {
+	c := r.config
+	r.spawn(func() error {
+		r.watchLag(ctx)
+		return nil
+	})
+	r.spawn(func() error {
+		WatchTransfers(ctx, r.mcSender, r.scSender, r.mc, r.sc, c.MainChainClient, c.SideChainClient,
+			c.SideChainWallet, c.Key, c.Store, r.scanner, r.fallbackTimeout, r.retry, r.stuckBlocks, r.pollInterval)
+		return nil
+	})
+	if r.mainChain {
+		r.spawn(func() error {
+			WatchMCDeposits(ctx, r.scSender, r.mc, r.sc, c.MainChainClient,
+				c.Store, r.scanner, r.mcConfirmations, r.pollInterval)
+			return nil
+		})
+	}
+	if r.sideChain {
+		r.spawn(func() error {
+			WatchSCDeposits(ctx, r.scSender, r.mc, r.sc, c.SideChainClient, c.MainChainClient, c.SideChainWallet, c.Key,
+				c.Store, r.scanner, r.scConfirmations, r.pollInterval)
+			return nil
+		})
+		r.spawn(func() error {
+			WatchSCSignatureAdded(ctx, r.mcSender, r.mc, r.sc, c.SideChainClient, c.MainChainClient, c.SideChainWallet,
+				c.Store, r.scanner, r.fallbackTimeout, r.scConfirmations, r.pollInterval)
+			return nil
+		})
+	}
+}
+
+// watchLag updates the lag metrics of the events relayed every poll interval, until ctx is cancelled
+func (r *Relayer) watchLag(ctx context.Context) {
+	heads := map[string]HeadReader{
+		mainChainLabel: r.config.MainChainClient,
+		sideChainLabel: r.config.SideChainClient,
+	}
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := observeLag(ctx, heads, r.config.Store, r.eventTypes()); err != nil && ctx.Err() == nil {
+			componentLogger("metrics").WithError(err).Error("can't update the lag")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce moves forward the transfers left part-way through, then processes the events
+// since the last checkpoints, the chains at the same time
+func (r *Relayer) runOnce(ctx context.Context) error {
+	c := r.config
+	err := ResumeTransfers(ctx, r.mcSender, r.scSender, r.mc, r.sc, c.MainChainClient, c.SideChainClient,
+		c.SideChainWallet, c.Key, c.Store, r.scanner, r.fallbackTimeout)
+	if err != nil {
+		return err
+	}
+	if r.stuckBlocks > 0 {
+		err := ReplaceStuckTransactions(ctx, r.mcSender, r.scSender, c.MainChainClient, c.SideChainClient, c.Store, r.stuckBlocks)
+		if err != nil {
+			return err
+		}
+	}
+	err = ProcessRetries(ctx, r.mcSender, r.scSender, r.mc, r.sc, c.MainChainClient, c.SideChainClient,
+		c.SideChainWallet, c.Key, c.Store, r.scanner, r.fallbackTimeout, r.retry)
+	if err != nil {
+		return err
+	}
+
+	if r.mainChain {
+		start, end, ok, err := r.blockRange(ctx, c.MainChainClient, "MCDeposit", r.mcConfirmations)
+		if err != nil {
+			return err
+		}
+		if ok {
+			r.spawn(func() error {
+				return ProcessMCDeposits(ctx, r.scSender, r.mc, r.sc, c.Store, r.scanner, start, end)
+			})
+		}
+	}
+	if r.sideChain {
+		dstart, dend, dok, err := r.blockRange(ctx, c.SideChainClient, "SCDeposit", r.scConfirmations)
+		if err != nil {
+			return err
+		}
+		sstart, send, sok, err := r.blockRange(ctx, c.SideChainClient, "SCSignatureAdded", r.scConfirmations)
+		if err != nil {
+			return err
+		}
+		if dok {
+			r.spawn(func() error {
+				return ProcessSCDeposits(ctx, r.scSender, r.mc, r.sc, c.MainChainClient, c.SideChainWallet, c.Key,
+					c.Store, r.scanner, dstart, dend)
+			})
+		}
+		if sok {
+			r.spawn(func() error {
+				return ProcessSCSignatureAdded(ctx, r.mcSender, r.mc, r.sc, c.MainChainClient, c.SideChainWallet,
+					c.Store, r.scanner, r.fallbackTimeout, sstart, send)
+			})
+		}
+	}
+	return nil
+}
+
+// blockRange checks the checkpoint of eventType for reorganizations, and returns the
+// range of blocks to process. ok is false if no block is confirmed yet.
+func (r *Relayer) blockRange(ctx context.Context, client ChainReader, eventType string,
+	confirmations uint64) (start uint64, end *uint64, ok bool, err error) {
+	if _, err := CheckReorg(ctx, client, r.config.Store, eventType); err != nil {
+		return 0, nil, false, err
+	}
+	start, err = ResumeBlock(r.config.Store, eventType)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	end, ok, err = ConfirmedEndBlock(ctx, client, EndBlock(start, r.nblocks), confirmations)
+	return start, end, ok, err
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestNewRelayer(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		key, _ := crypto.GenerateKey()
+		tests := []struct {
+			name   string
+			config Config
+		}{
+			{"Requires the clients", Config{Key: key, Store: store}},
+			{"Requires the key", Config{Store: store}},
+			{"Requires the store", Config{Key: key}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := NewRelayer(tt.config); err == nil {
+					t.Errorf("have = %v, want an error", err)
+				}
+			})
+		}
+	})
+}
+
+func TestRelayerLifecycle(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		key, _ := crypto.GenerateKey()
+		// A relayer without chains to relay only looks after the transfers of the store
+		newRelayer := func(opts ...Option) *Relayer {
+			r := &Relayer{
+				config:   Config{Key: key, Store: store},
+				mcSender: &Sender{},
+				scSender: &Sender{},
+				retry:    RetryPolicy{MaxAttempts: 1},
+			}
+			for _, opt := range append([]Option{WithChains(false, false)}, opts...) {
+				opt(r)
+			}
+			return r
+		}
+
+		t.Run("Waits for nothing before it is started", func(t *testing.T) {
+			if err := newRelayer().Wait(); err != nil {
+				t.Errorf("have = %v, want %v", err, nil)
+			}
+		})
+
+		t.Run("Stops by itself when it doesn't watch", func(t *testing.T) {
+			r := newRelayer()
+			if err := r.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Wait(); err != nil {
+				t.Errorf("have = %v, want %v", err, nil)
+			}
+			if status, _ := r.Status(); status.Running {
+				t.Errorf("have = %v, want %v", status.Running, false)
+			}
+		})
+
+		t.Run("Runs until stopped when it watches", func(t *testing.T) {
+			r := newRelayer(WithWatch(time.Millisecond))
+			if err := r.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Start(context.Background()); err == nil {
+				t.Errorf("have = %v, want an error", err)
+			}
+			if status, _ := r.Status(); !status.Running || !status.Watching {
+				t.Errorf("have = %v, %v, want %v, %v", status.Running, status.Watching, true, true)
+			}
+			r.Stop()
+			if err := r.Wait(); err != nil {
+				t.Errorf("have = %v, want %v", err, nil)
+			}
+			if status, _ := r.Status(); status.Running {
+				t.Errorf("have = %v, want %v", status.Running, false)
+			}
+		})
+
+		t.Run("Stops cleanly when its context is cancelled", func(t *testing.T) {
+			r := newRelayer(WithWatch(time.Millisecond))
+			ctx, cancel := context.WithCancel(context.Background())
+			if err := r.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			r.spawn(func() error {
+				<-ctx.Done()
+				return rpcError("filter deposits", ctx.Err())
+			})
+			cancel()
+			if err := r.Wait(); err != nil {
+				t.Errorf("have = %v, want %v", err, nil)
+			}
+		})
+
+		t.Run("Reports the first error", func(t *testing.T) {
+			r := newRelayer(WithWatch(time.Millisecond))
+			if err := r.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			first, second := make(chan struct{}), make(chan struct{})
+			want := storeError("get cursor", errors.New("disk full"))
+			r.spawn(func() error {
+				<-first
+				return want
+			})
+			r.spawn(func() error {
+				<-second
+				return rpcError("get head", errors.New("connection refused"))
+			})
+			close(first)
+			// The second error only happens once the first one is recorded
+			for status, _ := r.Status(); status.Err == nil; status, _ = r.Status() {
+				runtime.Gosched()
+			}
+			close(second)
+			r.Stop()
+			if err := r.Wait(); err != want {
+				t.Errorf("have = %v, want %v", err, want)
+			}
+		})
+
+		t.Run("Reports the next block of each event", func(t *testing.T) {
+			r := newRelayer(WithChains(true, false))
+			PersistLastBlock(store, "MCDeposit", 42)
+			status, err := r.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Blocks["MCDeposit"] != 42 || len(status.Blocks) != 1 {
+				t.Errorf("have = %v, want %v", status.Blocks, map[string]uint64{"MCDeposit": 42})
+			}
+		})
+	})
+}
@@ -0,0 +1,261 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// checkpointHistory is the number of checkpoints kept to find a common ancestor after a reorg
+const checkpointHistory = 128
+
+// ChainReader is the part of ethclient.Client needed to follow a chain and detect reorganizations
+type ChainReader interface {
+	HeadReader
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// Checkpoint is a processed event, identified by the block it was mined in, its transaction
+// and its index in the block
+type Checkpoint struct {
+	BlockNumber uint64
+	BlockHash   common.Hash
+	TxHash      common.Hash
+	LogIndex    uint
+}
+
+// logCheckpoint returns the checkpoint of an event log
+func logCheckpoint(l types.Log) Checkpoint {
+	return Checkpoint{BlockNumber: l.BlockNumber, BlockHash: l.BlockHash, TxHash: l.TxHash, LogIndex: l.Index}
+}
+
+// cursor returns the position of the checkpoint in its stream of events
+func (cp Checkpoint) cursor() Cursor {
+	return Cursor{BlockNumber: cp.BlockNumber, BlockHash: cp.BlockHash, LogIndex: cp.LogIndex}
+}
+
+// PersistCheckpoint saves the last processed block like PersistLastBlock, moves the cursor
+// of eventType to the checkpoint, and appends it to the recent history of eventType,
+// in a single transaction
+func PersistCheckpoint(store Store, eventType string, cp Checkpoint) error {
+	err := store.Update(func(tx StoreTx) error {
+		history, err := getCheckpoints(tx, eventType)
+		if err != nil {
+			return err
+		}
+		history = append(history, cp)
+		if len(history) > checkpointHistory {
+			history = history[len(history)-checkpointHistory:]
+		}
+		if err := putCheckpoints(tx, eventType, history); err != nil {
+			return err
+		}
+		if err := putCursor(tx, eventType, cp.cursor()); err != nil {
+			return err
+		}
+		return putLastBlock(tx, eventType, cp.BlockNumber)
+	})
+	return storeError("persist checkpoint", err)
+}
+
+// GetCheckpoints returns the recent checkpoints of eventType, oldest first
+func GetCheckpoints(store Store, eventType string) ([]Checkpoint, error) {
+	history, err := getCheckpoints(store, eventType)
+	return history, storeError("get checkpoints", err)
+}
+
+// getCheckpoints parses the history of eventType, saved one checkpoint per line.
+// The lines saved by the previous versions have no log index.
+func getCheckpoints(s storeReadWriter, eventType string) ([]Checkpoint, error) {
+	c, err := s.Get(eventType + ".history")
+	if err != nil {
+		return nil, err
+	}
+	var history []Checkpoint
+	scanner := bufio.NewScanner(bytes.NewReader(c))
+	for scanner.Scan() {
+		var cp Checkpoint
+		var blockHash, txHash string
+		line := scanner.Text()
+		if len(strings.Fields(line)) == 3 {
+			line += " 0"
+		}
+		if _, err := fmt.Sscanf(line, "%d %s %s %d", &cp.BlockNumber, &blockHash, &txHash, &cp.LogIndex); err != nil {
+			return nil, err
+		}
+		cp.BlockHash = common.HexToHash(blockHash)
+		cp.TxHash = common.HexToHash(txHash)
+		history = append(history, cp)
+	}
+	return history, scanner.Err()
+}
+
+func putCheckpoints(s storeReadWriter, eventType string, history []Checkpoint) error {
+	var b bytes.Buffer
+	for _, cp := range history {
+		fmt.Fprintf(&b, "%d %s %s %d\n", cp.BlockNumber, cp.BlockHash.Hex(), cp.TxHash.Hex(), cp.LogIndex)
+	}
+	return s.Put(eventType+".history", b.Bytes())
+}
+
+// CheckReorg compares the recent checkpoints of eventType with the chain. If some of them
+// were mined in blocks that are no longer part of the chain, the checkpoint is rolled back to
+// the most recent block that still is, and the transactions of the dropped checkpoints are
+// checked again. It returns true if the checkpoint was rolled back.
+func CheckReorg(ctx context.Context, client ChainReader, store Store, eventType string) (bool, error) {
+	history, err := GetCheckpoints(store, eventType)
+	if err != nil {
+		return false, err
+	}
+	canonical := map[uint64]bool{}
+	i := len(history)
+	for ; i > 0; i-- {
+		cp := history[i-1]
+		onChain, checked := canonical[cp.BlockNumber]
+		if !checked {
+			header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(cp.BlockNumber))
+			if err != nil && err != ethereum.NotFound {
+				return false, rpcError("get header", err)
+			}
+			onChain = err == nil && header.Hash() == cp.BlockHash
+			canonical[cp.BlockNumber] = onChain
+		}
+		if onChain {
+			break
+		}
+	}
+	if i == len(history) {
+		return false, nil
+	}
+
+	// Roll back to the common ancestor. If none of the recorded blocks is left,
+	// restart right after the block before the oldest one.
+	dropped := history[i:]
+	var ancestor uint64
+	var cursor Cursor
+	if i > 0 {
+		ancestor = history[i-1].BlockNumber
+		cursor = history[i-1].cursor()
+	} else if dropped[0].BlockNumber > 0 {
+		ancestor = dropped[0].BlockNumber - 1
+		cursor = Cursor{BlockNumber: ancestor, LogIndex: wholeBlock}
+	}
+	componentLogger("reorg").With(Fields{"event": eventType, "from": dropped[len(dropped)-1].BlockNumber, "to": ancestor}).Warn("rolling back the checkpoint")
+	err = store.Update(func(tx StoreTx) error {
+		if err := putCheckpoints(tx, eventType, history[:i]); err != nil {
+			return err
+		}
+		if i > 0 || ancestor > 0 {
+			if err := putCursor(tx, eventType, cursor); err != nil {
+				return err
+			}
+		} else if err := tx.Delete(eventType + ".cursor"); err != nil {
+			return err
+		}
+		return putLastBlock(tx, eventType, ancestor)
+	})
+	if err != nil {
+		return false, storeError("roll back checkpoint", err)
+	}
+	if eventType == "SCSignatureAdded" {
+		if err := rollbackSignatures(store, ancestor); err != nil {
+			return false, err
+		}
+	}
+	if eventType == "MCDeposit" {
+		if err := rollbackExecutions(store, ancestor); err != nil {
+			return false, err
+		}
+	}
+
+	return true, recheckTransfers(ctx, client, store, eventType, dropped)
+}
+
+// recheckTransfers looks for the transactions of checkpoints dropped by a reorg on the chain.
+// Those that were mined again are processed again from the rolled back checkpoint, the others
+// were acted upon but no longer exist: the transfers of those deposits are removed.
+func recheckTransfers(ctx context.Context, client ChainReader, store Store, eventType string, dropped []Checkpoint) error {
+	for _, cp := range dropped {
+		receipt, err := client.TransactionReceipt(ctx, cp.TxHash)
+		if err == ethereum.NotFound {
+			componentLogger("reorg").With(Fields{"event": eventType, "sourceTx": cp.TxHash, "block": cp.BlockNumber}).Warn("processed but no longer on the chain")
+			if eventType == "MCDeposit" || eventType == "SCDeposit" {
+				if err := removeTransfer(store, cp.TxHash); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return rpcError("get receipt", err)
+		}
+		componentLogger("reorg").With(Fields{"event": eventType, "sourceTx": cp.TxHash, "block": cp.BlockNumber, "newBlock": receipt.BlockNumber}).Info("moved to another block")
+	}
+	return nil
+}
+
+// removeTransfer records that the deposit sourceTx is no longer on its chain, and takes its
+// submissions out of the retry queue, so that nothing more is sent for it
+func removeTransfer(store Store, sourceTx common.Hash) error {
+	t, err := GetTransfer(store, sourceTx)
+	if err != nil {
+		return err
+	}
+	if t != nil && t.State != TransferRemoved {
+		logger := transferLogger(t.Direction, t.SourceTx, t.SourceBlock)
+		if t.State == TransferExecuted {
+			logger.Error("executed, but the deposit is no longer on the chain")
+			return nil
+		}
+		if err := t.Advance(TransferRemoved, common.Hash{}, errLogRemoved); err != nil {
+			return err
+		}
+		if err := PutTransfer(store, t); err != nil {
+			return err
+		}
+		logger.Warn("removed, the deposit is no longer on the chain")
+	}
+	for _, kind := range []string{RetryVote, RetrySignature, RetryWithdrawal} {
+		entry := &RetryEntry{Kind: kind, SourceTx: sourceTx}
+		if err := clearRetry(store, std, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// depositRemoved tells if the deposit sourceTx is no longer on the chain of client. A transfer
+// whose deposit was removed is recorded as such, see removeTransfer.
+func depositRemoved(ctx context.Context, client ChainReader, store Store, sourceTx common.Hash) (bool, error) {
+	_, err := client.TransactionReceipt(ctx, sourceTx)
+	if err == ethereum.NotFound {
+		return true, removeTransfer(store, sourceTx)
+	}
+	return false, rpcError("get receipt", err)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestCheckReorg(t *testing.T) {
+	dbPath, _ := ioutil.TempDir("", "icn")
+	defer os.RemoveAll(dbPath)
+	store, _ := NewFileStore(dbPath)
+	ctx := context.Background()
+
+	chain := &fakeChain{headers: map[uint64]*types.Header{}, receipts: map[common.Hash]*types.Receipt{}}
+	for n := int64(1); n <= 5; n++ {
+		chain.headers[uint64(n)] = &types.Header{Number: big.NewInt(n)}
+	}
+
+	kept := Checkpoint{BlockNumber: 2, BlockHash: chain.headers[2].Hash(), TxHash: common.HexToHash("01")}
+	moved := Checkpoint{BlockNumber: 3, BlockHash: common.HexToHash("dead"), TxHash: common.HexToHash("02")}
+	lost := Checkpoint{BlockNumber: 4, BlockHash: common.HexToHash("beef"), TxHash: common.HexToHash("03")}
+	chain.receipts[moved.TxHash] = &types.Receipt{BlockNumber: big.NewInt(5)}
+	for _, cp := range []Checkpoint{kept, moved, lost} {
+		if err := PersistCheckpoint(store, "MCDeposit", cp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The sealer voted for the lost deposit, and its vote is queued for a retry
+	lostTransfer, _ := observeTransfer(store, MainChainToSideChain, types.Log{TxHash: lost.TxHash, BlockNumber: 4}, common.HexToAddress("0x70"), big.NewInt(1))
+	lostTransfer.Advance(TransferVoted, common.HexToHash("0x04"), nil)
+	PutTransfer(store, lostTransfer)
+	lostRetry := &RetryEntry{Kind: RetryVote, SourceTx: lost.TxHash, SourceBlock: 4}
+	scheduleRetry(store, std, lostRetry, errors.New("nonce too low"))
+
+	t.Run("Rolls back to the common ancestor", func(t *testing.T) {
+		rolledBack, err := CheckReorg(ctx, chain, store, "MCDeposit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rolledBack {
+			t.Errorf("rolledBack = %v, want %v", rolledBack, true)
+		}
+		if have, _ := GetLastProcessedBlock(store, "MCDeposit"); have != 2 {
+			t.Errorf("have = %v, want %v", have, 2)
+		}
+		if have, _ := GetCheckpoints(store, "MCDeposit"); !reflect.DeepEqual(have, []Checkpoint{kept}) {
+			t.Errorf("have = %v, want %v", have, []Checkpoint{kept})
+		}
+		if have, _ := GetCursor(store, "MCDeposit"); have == nil || *have != kept.cursor() {
+			t.Errorf("have = %v, want %v", have, kept.cursor())
+		}
+	})
+
+	t.Run("Removes the transfers of the deposits no longer on the chain", func(t *testing.T) {
+		transfer, _ := GetTransfer(store, lost.TxHash)
+		if transfer == nil || transfer.State != TransferRemoved {
+			t.Errorf("have = %v, want %v", transfer, TransferRemoved)
+		}
+		if c, _ := store.Get(lostRetry.key("retry/")); c != nil {
+			t.Errorf("have = %s, want no retry", c)
+		}
+	})
+
+	t.Run("Confirms a removed deposit again when it is mined again", func(t *testing.T) {
+		transfer, err := observeTransfer(store, MainChainToSideChain, types.Log{TxHash: lost.TxHash, BlockNumber: 6}, common.HexToAddress("0x70"), big.NewInt(1))
+		if err != nil || transfer.State != TransferConfirmed || transfer.SourceBlock != 6 {
+			t.Errorf("have = %v, %v, want %v in block %v", transfer, err, TransferConfirmed, 6)
+		}
+	})
+
+	t.Run("Does nothing when the checkpoints are on the chain", func(t *testing.T) {
+		rolledBack, err := CheckReorg(ctx, chain, store, "MCDeposit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rolledBack {
+			t.Errorf("rolledBack = %v, want %v", rolledBack, false)
+		}
+	})
+}
+
+func TestCheckReorgSignatures(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		chain := &fakeChain{headers: map[uint64]*types.Header{}, receipts: map[common.Hash]*types.Receipt{}}
+		for n := int64(1); n <= 5; n++ {
+			chain.headers[uint64(n)] = &types.Header{Number: big.NewInt(n)}
+		}
+		withdrawal := common.HexToHash("0x01")
+		signature := func(block uint64) *sidechain.SideChainSignatureAdded {
+			return &sidechain.SideChainSignatureAdded{TxHash: withdrawal, Raw: types.Log{BlockNumber: block, TxHash: common.BigToHash(big.NewInt(int64(block)))}}
+		}
+		for _, block := range []uint64{2, 4} {
+			if err := indexSignature(store, signature(block)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		PersistCheckpoint(store, "SCSignatureAdded", Checkpoint{BlockNumber: 2, BlockHash: chain.headers[2].Hash(), TxHash: common.HexToHash("0x02")})
+		PersistCheckpoint(store, "SCSignatureAdded", Checkpoint{BlockNumber: 4, BlockHash: common.HexToHash("beef"), TxHash: common.HexToHash("0x04")})
+
+		if _, err := CheckReorg(ctx, chain, store, "SCSignatureAdded"); err != nil {
+			t.Fatal(err)
+		}
+		sigs, _ := GetSignatures(store, withdrawal)
+		if len(sigs) != 1 || sigs[0].Block != 2 {
+			t.Errorf("have = %v, want the signature of block %v", sigs, 2)
+		}
+		if have, _ := GetLastProcessedBlock(store, signatureIndexBlock); have != 2 {
+			t.Errorf("have = %v, want %v", have, 2)
+		}
+	})
+}
+
+func TestGetCheckpointsWithoutLogIndex(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		blockHash, txHash := common.HexToHash("01"), common.HexToHash("02")
+		store.Put("MCDeposit.history", []byte("7 "+blockHash.Hex()+" "+txHash.Hex()+"\n"))
+		have, err := GetCheckpoints(store, "MCDeposit")
+		want := []Checkpoint{{BlockNumber: 7, BlockHash: blockHash, TxHash: txHash}}
+		if err != nil || !reflect.DeepEqual(have, want) {
+			t.Errorf("have = %v, %v, want %v, %v", have, err, want, nil)
+		}
+	})
+}
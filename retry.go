@@ -0,0 +1,330 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Kinds of submissions that can be retried
+const (
+	// RetryVote is a vote on the side chain for a main chain deposit
+	RetryVote = "vote"
+	// RetrySignature is a signature on the side chain for a side chain deposit
+	RetrySignature = "signature"
+	// RetryWithdrawal is a withdrawal on the main chain for a side chain deposit
+	RetryWithdrawal = "withdrawal"
+)
+
+// RetryEntry is a failed submission waiting to be sent again
+type RetryEntry struct {
+	Kind        string         `json:"kind"`
+	SourceTx    common.Hash    `json:"sourceTx"`
+	SourceBlock uint64         `json:"sourceBlock"`
+	To          common.Address `json:"to"`
+	Value       *big.Int       `json:"value"`
+	Attempts    int            `json:"attempts"`
+	LastAttempt time.Time      `json:"lastAttempt"`
+	LastError   string         `json:"lastError"`
+	// NextAttempt is when a submission that had nothing to send yet is tried again,
+	// instead of after the backoff delay
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
+}
+
+// due tells if the entry should be tried again now
+func (e *RetryEntry) due(policy RetryPolicy) bool {
+	if !e.NextAttempt.IsZero() {
+		return !time.Now().Before(e.NextAttempt)
+	}
+	return time.Since(e.LastAttempt) >= policy.Backoff(e.Attempts)
+}
+
+func (e *RetryEntry) key(prefix string) string {
+	return prefix + e.Kind + "/" + e.SourceTx.Hex()
+}
+
+// RetryPolicy tells how often and how many times failed submissions are sent again
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// Backoff returns how long to wait after the given number of failed attempts. The delay
+// doubles after each attempt, up to MaxDelay.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// retryKind returns the kind of the last submission sent for a transfer
+func retryKind(t *Transfer) string {
+	if t.State == TransferSubmitted {
+		return RetryWithdrawal
+	}
+	if t.Direction == MainChainToSideChain {
+		return RetryVote
+	}
+	return RetrySignature
+}
+
+// scheduleRetry records a failed attempt of a submission in the retry queue. If it can't,
+// the error is returned: the event must not be checkpointed, or the submission would be lost.
+func scheduleRetry(store Store, logger *Logger, entry *RetryEntry, cause error) error {
+	var attempts int
+	err := store.Update(func(tx StoreTx) error {
+		c, err := tx.Get(entry.key("retry/"))
+		if err != nil {
+			return err
+		}
+		e := *entry
+		if c != nil {
+			if err := json.Unmarshal(c, &e); err != nil {
+				return err
+			}
+		}
+		e.Attempts++
+		attempts = e.Attempts
+		e.LastAttempt = time.Now()
+		e.LastError = cause.Error()
+		e.NextAttempt = time.Time{}
+		c, err = json.Marshal(&e)
+		if err != nil {
+			return err
+		}
+		return tx.Put(e.key("retry/"), c)
+	})
+	if err != nil {
+		err = storeError("queue retry", err)
+		logger.WithError(err).Error("can't queue the retry")
+		return err
+	}
+	logger.With(Fields{"attempt": attempts}).Info("queued for a retry")
+	return nil
+}
+
+// deferRetry sets when an entry that had nothing to send yet is tried again, without
+// counting it as a failed attempt
+func deferRetry(store Store, logger *Logger, entry *RetryEntry, next time.Time) error {
+	err := store.Update(func(tx StoreTx) error {
+		c, err := tx.Get(entry.key("retry/"))
+		if err != nil || c == nil {
+			return err
+		}
+		var e RetryEntry
+		if err := json.Unmarshal(c, &e); err != nil {
+			return err
+		}
+		e.NextAttempt = next
+		c, err = json.Marshal(&e)
+		if err != nil {
+			return err
+		}
+		return tx.Put(e.key("retry/"), c)
+	})
+	if err != nil {
+		err = storeError("defer retry", err)
+		logger.WithError(err).Error("can't defer the retry")
+	}
+	return err
+}
+
+// clearRetry removes a submission that went through, or that is no longer needed, from
+// the retry queue
+func clearRetry(store Store, logger *Logger, entry *RetryEntry) error {
+	c, err := store.Get(entry.key("retry/"))
+	if err == nil && c != nil {
+		err = store.Update(func(tx StoreTx) error {
+			return tx.Delete(entry.key("retry/"))
+		})
+	}
+	if err != nil {
+		err = storeError("clear retry", err)
+		logger.WithError(err).Error("can't clear the retry")
+	}
+	return err
+}
+
+// retryLogger returns the logger of the transfer of a retry entry
+func retryLogger(e *RetryEntry) *Logger {
+	direction := MainChainToSideChain
+	if e.Kind != RetryVote {
+		direction = SideChainToMainChain
+	}
+	return transferLogger(direction, e.SourceTx, e.SourceBlock).With(Fields{"kind": e.Kind, "attempt": e.Attempts})
+}
+
+// ProcessRetries sends again the failed submissions whose backoff delay has elapsed.
+// Those that already failed MaxAttempts times are moved to the dead letters instead, and
+// those whose deposit is no longer on its chain are dropped. An entry that can't be sent
+// is queued again and doesn't hold back the others.
+func ProcessRetries(ctx context.Context, mcSender *Sender, scSender *Sender,
+	mc MainChainBackend, sc SideChainBackend,
+	mcClient ChainReader, scClient ChainReader, addr common.Address, key *ecdsa.PrivateKey,
+	store Store, scanner *LogScanner, fallbackTimeout time.Duration, policy RetryPolicy) error {
+	var due, dead []RetryEntry
+	err := store.ForEach("retry/", func(k string, value []byte) error {
+		var e RetryEntry
+		if err := json.Unmarshal(value, &e); err != nil {
+			return err
+		}
+		if e.Attempts >= policy.MaxAttempts {
+			dead = append(dead, e)
+		} else if e.due(policy) {
+			due = append(due, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return storeError("read retry queue", err)
+	}
+
+	for _, e := range dead {
+		retryLogger(&e).With(Fields{"lastError": e.LastError}).Error("failed too many times, moving it to the dead letters")
+		if err := moveRetry(store, &e, "retry/", "deadletter/"); err != nil {
+			return storeError("move to dead letters", err)
+		}
+	}
+	for _, e := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logger := retryLogger(&e)
+		client := scClient
+		if e.Kind == RetryVote {
+			client = mcClient
+		}
+		removed, err := depositRemoved(ctx, client, store, e.SourceTx)
+		if err != nil {
+			// The other entries are tried anyway, this one is queued again
+			logger.WithError(err).Error("can't check the deposit")
+			scheduleRetry(store, logger, &e, err)
+			continue
+		}
+		if removed {
+			continue
+		}
+		logger.With(Fields{"attempt": e.Attempts + 1}).Info("retrying")
+		raw := types.Log{TxHash: e.SourceTx, BlockNumber: e.SourceBlock}
+		var outcome relayOutcome
+		switch e.Kind {
+		case RetryVote:
+			outcome, err = relayMCDeposit(ctx, scSender, sc, store, &mainchain.MainChainDeposit{To: e.To, Value: e.Value, Raw: raw})
+		case RetrySignature:
+			outcome, err = relaySCDeposit(ctx, scSender, mc, sc, mcClient, addr, key, store, scanner, &sidechain.SideChainDeposit{To: e.To, Value: e.Value, Raw: raw})
+		case RetryWithdrawal:
+			outcome, err = relaySCSignatureAdded(ctx, mcSender, mc, sc, mcClient, addr, store, scanner, fallbackTimeout, &sidechain.SideChainSignatureAdded{TxHash: e.SourceTx, Raw: raw})
+		}
+		if err != nil {
+			logger.WithError(err).Error("can't retry")
+			scheduleRetry(store, logger, &e, err)
+			continue
+		}
+		if outcome == relayWaiting {
+			deferRetry(store, logger, &e, nextRetry(ctx, mcSender, sc, store, fallbackTimeout, policy, &e))
+		}
+	}
+	return nil
+}
+
+// nextRetry returns when to try again a withdrawal that had nothing to send yet: once the
+// sealers before this one in the rotation had their turn if it is left to them, or after
+// the backoff delay if it is still missing signatures
+func nextRetry(ctx context.Context, sender *Sender, sc SideChainCaller, store Store,
+	fallbackTimeout time.Duration, policy RetryPolicy, e *RetryEntry) time.Time {
+	next := time.Now().Add(policy.Backoff(e.Attempts))
+	t, err := GetTransfer(store, e.SourceTx)
+	if err != nil || t == nil || t.ReadyAt.IsZero() {
+		return next
+	}
+	delay, err := submitterDelay(ctx, sc, sender.Auth.From, e.SourceTx, fallbackTimeout)
+	if err != nil {
+		return next
+	}
+	return t.ReadyAt.Add(delay)
+}
+
+// moveRetry moves an entry between the retry queue and the dead letters
+func moveRetry(store Store, e *RetryEntry, from string, to string) error {
+	c, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return store.Update(func(tx StoreTx) error {
+		if err := tx.Delete(e.key(from)); err != nil {
+			return err
+		}
+		return tx.Put(e.key(to), c)
+	})
+}
+
+// DeadLetters returns the submissions that failed too many times to be retried
+func DeadLetters(store Store) ([]RetryEntry, error) {
+	var entries []RetryEntry
+	err := store.ForEach("deadletter/", func(k string, value []byte) error {
+		var e RetryEntry
+		if err := json.Unmarshal(value, &e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, storeError("read dead letters", err)
+}
+
+// Redrive moves the dead letters of sourceTx back to the retry queue, with a fresh
+// number of attempts. They are sent again the next time the retries are processed.
+func Redrive(store Store, sourceTx common.Hash) error {
+	entries, err := DeadLetters(store)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, e := range entries {
+		if e.SourceTx != sourceTx {
+			continue
+		}
+		found = true
+		e.Attempts = 0
+		if err := moveRetry(store, &e, "deadletter/", "retry/"); err != nil {
+			return storeError("redrive", err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("no dead letter for %s", sourceTx.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialDelay: time.Minute, MaxDelay: 10 * time.Minute}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if have := policy.Backoff(tt.attempts); have != tt.want {
+			t.Errorf("Backoff(%d): have = %v, want %v", tt.attempts, have, tt.want)
+		}
+	}
+}
+
+func TestRetryQueue(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		entry := &RetryEntry{
+			Kind:     RetryVote,
+			SourceTx: common.HexToHash("0x1"),
+			To:       common.HexToAddress("0x2"),
+			Value:    big.NewInt(3),
+		}
+		policy := RetryPolicy{MaxAttempts: 2, InitialDelay: time.Hour, MaxDelay: time.Hour}
+
+		t.Run("Failed attempts are counted", func(t *testing.T) {
+			scheduleRetry(store, std, entry, errors.New("nonce too low"))
+			scheduleRetry(store, std, entry, errors.New("replacement transaction underpriced"))
+			var e RetryEntry
+			c, _ := store.Get(entry.key("retry/"))
+			if err := json.Unmarshal(c, &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Attempts != 2 || e.LastError != "replacement transaction underpriced" {
+				t.Errorf("have = %d, %s, want %d, %s", e.Attempts, e.LastError, 2, "replacement transaction underpriced")
+			}
+		})
+
+		t.Run("Entries out of attempts go to the dead letters", func(t *testing.T) {
+			if err := ProcessRetries(context.Background(), nil, nil, nil, nil, nil, nil, common.Address{}, nil, store, nil, 0, policy); err != nil {
+				t.Fatal(err)
+			}
+			c, _ := store.Get(entry.key("retry/"))
+			dead, err := DeadLetters(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != nil || len(dead) != 1 || dead[0].SourceTx != entry.SourceTx || dead[0].Value.Cmp(entry.Value) != 0 {
+				t.Errorf("have = %s, %v, want %v, %v", c, dead, nil, entry)
+			}
+		})
+
+		t.Run("Dead letters can be redriven", func(t *testing.T) {
+			if err := Redrive(store, entry.SourceTx); err != nil {
+				t.Fatal(err)
+			}
+			dead, _ := DeadLetters(store)
+			c, _ := store.Get(entry.key("retry/"))
+			if len(dead) != 0 || c == nil {
+				t.Errorf("have = %v, %s, want no dead letters and a retry", dead, c)
+			}
+		})
+
+		t.Run("Redriving an unknown transfer fails", func(t *testing.T) {
+			if err := Redrive(store, common.HexToHash("0x4")); err == nil {
+				t.Errorf("have = %v, want an error", err)
+			}
+		})
+
+		t.Run("Entries are removed once they went through", func(t *testing.T) {
+			clearRetry(store, std, entry)
+			c, _ := store.Get(entry.key("retry/"))
+			if c != nil {
+				t.Errorf("have = %s, want %v", c, nil)
+			}
+		})
+	})
+}
+
+func TestProcessRetriesRemovedDeposit(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		entry := &RetryEntry{Kind: RetryVote, SourceTx: common.HexToHash("0x1"), SourceBlock: 3}
+		scheduleRetry(store, std, entry, errors.New("nonce too low"))
+		policy := RetryPolicy{MaxAttempts: 5}
+
+		// The main chain no longer has the deposit, nothing is sent to the side chain
+		chain := &fakeChain{receipts: map[common.Hash]*types.Receipt{}}
+		if err := ProcessRetries(context.Background(), nil, nil, nil, nil, chain, nil, common.Address{}, nil, store, nil, 0, policy); err != nil {
+			t.Fatal(err)
+		}
+		if c, _ := store.Get(entry.key("retry/")); c != nil {
+			t.Errorf("have = %s, want no retry", c)
+		}
+	})
+}
+
+func TestProcessRetriesFailure(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		flaky := &RetryEntry{Kind: RetryVote, SourceTx: common.HexToHash("0x1"), SourceBlock: 3}
+		removed := &RetryEntry{Kind: RetryVote, SourceTx: common.HexToHash("0x2"), SourceBlock: 4}
+		scheduleRetry(store, std, flaky, errors.New("nonce too low"))
+		scheduleRetry(store, std, removed, errors.New("nonce too low"))
+		policy := RetryPolicy{MaxAttempts: 5}
+
+		// The entry whose deposit can't be checked doesn't hold back the other one
+		chain := &flakyChain{fakeChain: &fakeChain{}, failing: flaky.SourceTx}
+		if err := ProcessRetries(context.Background(), nil, nil, nil, nil, chain, nil, common.Address{}, nil, store, nil, 0, policy); err != nil {
+			t.Fatal(err)
+		}
+		if c, _ := store.Get(removed.key("retry/")); c != nil {
+			t.Errorf("have = %s, want no retry", c)
+		}
+		var e RetryEntry
+		c, _ := store.Get(flaky.key("retry/"))
+		if err := json.Unmarshal(c, &e); err != nil || e.Attempts != 2 {
+			t.Errorf("have = %v, %v, want %v attempts", e.Attempts, err, 2)
+		}
+	})
+}
+
+func TestDeferRetry(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		a, b, sealer := common.HexToAddress("0xa"), common.HexToAddress("0xb"), common.HexToAddress("0xc")
+		entry := &RetryEntry{Kind: RetryWithdrawal, SourceTx: common.HexToHash("0x1")}
+		scheduleRetry(store, std, entry, errors.New("nonce too low"))
+		policy := RetryPolicy{MaxAttempts: 5}
+		readyAt := time.Now().Add(-time.Minute)
+		PutTransfer(store, &Transfer{Direction: SideChainToMainChain, SourceTx: entry.SourceTx, State: TransferConfirmed, ReadyAt: readyAt})
+
+		// The sealer isn't an owner, it waits for both of them
+		sc := &traceSideChain{owners: []common.Address{a, b}}
+		sender := &Sender{Auth: &bind.TransactOpts{From: sealer}}
+		next := nextRetry(context.Background(), sender, sc, store, time.Hour, policy, entry)
+		if want := readyAt.Add(2 * time.Hour); !next.Equal(want) {
+			t.Errorf("have = %v, want %v", next, want)
+		}
+
+		t.Run("Entries waiting for their turn are not due", func(t *testing.T) {
+			if err := deferRetry(store, std, entry, next); err != nil {
+				t.Fatal(err)
+			}
+			var e RetryEntry
+			c, _ := store.Get(entry.key("retry/"))
+			json.Unmarshal(c, &e)
+			if e.due(policy) || e.Attempts != 1 {
+				t.Errorf("have = %v, %v, want %v, %v", e.due(policy), e.Attempts, false, 1)
+			}
+		})
+
+		t.Run("Failed attempts are due after the backoff again", func(t *testing.T) {
+			scheduleRetry(store, std, entry, errors.New("nonce too low"))
+			var e RetryEntry
+			c, _ := store.Get(entry.key("retry/"))
+			json.Unmarshal(c, &e)
+			if !e.due(policy) || e.Attempts != 2 {
+				t.Errorf("have = %v, %v, want %v, %v", e.due(policy), e.Attempts, true, 2)
+			}
+		})
+	})
+}
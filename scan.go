@@ -0,0 +1,173 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"strings"
+)
+
+// tooManyResultsErrors are parts of the errors returned by nodes and hosted providers
+// that refuse a log query because the range holds too many results. The providers also
+// say "limit exceeded" when they throttle requests, which a smaller window doesn't help.
+var tooManyResultsErrors = []string{
+	"query returned more than",
+	"too many results",
+	"response size exceeded",
+	"response size should not",
+}
+
+// tooManyResults tells if err is a node refusing a log query that is too large
+func tooManyResults(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range tooManyResultsErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogScanner reads the events of a chain in windows of blocks, so that no request covers
+// more blocks or returns more logs than the node accepts
+type LogScanner struct {
+	// Window is the number of blocks read at once. Ranges are read at once if it is 0.
+	Window uint64
+	// Workers is the number of windows Backfill reads at the same time
+	Workers int
+}
+
+// A FetchFunc reads the events of a window of blocks and returns the function that
+// processes them. Fetching can run concurrently, processing never does.
+type FetchFunc func(from uint64, to *uint64) (process func() error, err error)
+
+// Scan calls scan with consecutive windows of blocks from start to end, stopping at the
+// first error. When the node refuses a window because it holds too many logs, the window
+// is halved and the same blocks are read again. An open range is read at once.
+func (s *LogScanner) Scan(start uint64, end *uint64, scan func(from uint64, to *uint64) error) error {
+	if s == nil || s.Window == 0 || end == nil {
+		return scan(start, end)
+	}
+	window := s.Window
+	for from := start; from <= *end; {
+		to := from + window - 1
+		if to > *end {
+			to = *end
+		}
+		err := scan(from, &to)
+		if err != nil && window > 1 && tooManyResults(err) {
+			window /= 2
+			componentLogger("scan").With(Fields{"from": from, "to": to, "window": window}).WithError(err).Warn("window refused, halving it")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		from = to + 1
+	}
+	return nil
+}
+
+// Backfill reads the windows from start to end like Scan, with up to Workers of them
+// fetched at the same time, and processes their events strictly in block order. No window
+// is processed before all the windows preceding it were, so a crash can't skip blocks.
+// When a window can't be fetched, the windows before it are processed and the error returned.
+func (s *LogScanner) Backfill(start uint64, end *uint64, fetch FetchFunc) error {
+	if s == nil || s.Workers <= 1 || s.Window == 0 || end == nil {
+		return s.Scan(start, end, func(from uint64, to *uint64) error {
+			process, err := fetch(from, to)
+			if err != nil {
+				return err
+			}
+			return process()
+		})
+	}
+
+	type fetched struct {
+		process []func() error
+		err     error
+	}
+	done := make(chan struct{})
+	defer close(done)
+	// Windows are fetched at most Workers ahead of the one being processed
+	slots := make(chan struct{}, s.Workers)
+	pending := make(chan chan fetched, s.Workers)
+	go func() {
+		defer close(pending)
+		for from := start; from <= *end; {
+			to := from + s.Window - 1
+			if to > *end {
+				to = *end
+			}
+			result := make(chan fetched, 1)
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case pending <- result:
+			case <-done:
+				return
+			}
+			go func(from, to uint64) {
+				var f fetched
+				// A window refused by the node is split in smaller ones, processed in order
+				window := &LogScanner{Window: to - from + 1}
+				f.err = window.Scan(from, &to, func(from uint64, to *uint64) error {
+					process, err := fetch(from, to)
+					if err == nil {
+						f.process = append(f.process, process)
+					}
+					return err
+				})
+				result <- f
+			}(from, to)
+			from = to + 1
+		}
+	}()
+
+	for result := range pending {
+		f := <-result
+		for _, process := range f.process {
+			if err := process(); err != nil {
+				return err
+			}
+		}
+		if f.err != nil {
+			return f.err
+		}
+		<-slots
+	}
+	return nil
+}
+
+// checkpointWindow records that the events up to the end of a window were processed
+func checkpointWindow(store Store, eventType string, to *uint64) error {
+	if to == nil {
+		return nil
+	}
+	err := store.Update(func(tx StoreTx) error {
+		if err := advanceCursor(tx, eventType, *to); err != nil {
+			return err
+		}
+		return advanceLastBlock(tx, eventType, *to)
+	})
+	return storeError("checkpoint window", err)
+}
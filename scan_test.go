@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogScanner(t *testing.T) {
+	type window struct{ from, to uint64 }
+	var nine uint64 = 9
+	tests := []struct {
+		name    string
+		scanner *LogScanner
+		end     *uint64
+		refuse  uint64
+		want    []window
+	}{
+		{
+			name:    "Reads the range in windows",
+			scanner: &LogScanner{Window: 4},
+			end:     &nine,
+			want:    []window{{0, 3}, {4, 7}, {8, 9}},
+		},
+		{
+			name:    "Reads the range at once without a window",
+			scanner: &LogScanner{},
+			end:     &nine,
+			want:    []window{{0, 9}},
+		},
+		{
+			name:    "Reads the range at once without a scanner",
+			scanner: nil,
+			end:     &nine,
+			want:    []window{{0, 9}},
+		},
+		{
+			name:    "Reads an open range at once",
+			scanner: &LogScanner{Window: 4},
+			end:     nil,
+			want:    []window{{0, 0}},
+		},
+		{
+			name:    "Halves the window when the node refuses it",
+			scanner: &LogScanner{Window: 8},
+			end:     &nine,
+			refuse:  4,
+			want:    []window{{0, 3}, {4, 7}, {8, 9}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var have []window
+			err := tt.scanner.Scan(0, tt.end, func(from uint64, to *uint64) error {
+				if to == nil {
+					have = append(have, window{from, 0})
+					return nil
+				}
+				if tt.refuse > 0 && *to-from+1 > tt.refuse {
+					return errors.New("query returned more than 10000 results")
+				}
+				have = append(have, window{from, *to})
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("have = %v, want %v", have, tt.want)
+			}
+		})
+	}
+
+	t.Run("Keeps the window when the node throttles requests", func(t *testing.T) {
+		scanner := &LogScanner{Window: 4}
+		var have []window
+		throttled := true
+		scan := func(from uint64, to *uint64) error {
+			if throttled {
+				throttled = false
+				return errors.New("daily request count exceeded, request rate limited: limit exceeded")
+			}
+			have = append(have, window{from, *to})
+			return nil
+		}
+		if err := scanner.Scan(0, &nine, scan); err == nil {
+			t.Errorf("have = %v, want an error", err)
+		}
+		// The next run reads whole windows again
+		if err := scanner.Scan(0, &nine, scan); err != nil {
+			t.Fatal(err)
+		}
+		want := []window{{0, 3}, {4, 7}, {8, 9}}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("have = %v, want %v", have, want)
+		}
+	})
+
+	t.Run("Stops at other errors", func(t *testing.T) {
+		calls := 0
+		want := errors.New("connection refused")
+		err := (&LogScanner{Window: 4}).Scan(0, &nine, func(from uint64, to *uint64) error {
+			calls++
+			return want
+		})
+		if err != want || calls != 1 {
+			t.Errorf("have = %v, %d, want %v, %d", err, calls, want, 1)
+		}
+	})
+}
+
+func TestCheckpointWindow(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		var five, nine uint64 = 5, 9
+		for _, to := range []*uint64{&nine, &five, nil} {
+			if err := checkpointWindow(store, "MCDeposit", to); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if have, _ := GetLastProcessedBlock(store, "MCDeposit"); have != nine {
+			t.Errorf("have = %d, want %d", have, nine)
+		}
+	})
+}
+
+func TestLogScannerBackfill(t *testing.T) {
+	var end uint64 = 99
+	scanner := &LogScanner{Window: 10, Workers: 4}
+
+	t.Run("Processes the windows in block order", func(t *testing.T) {
+		var processed []uint64
+		err := scanner.Backfill(0, &end, func(from uint64, to *uint64) (func() error, error) {
+			// Later windows are fetched faster, processing must still wait for the earlier ones
+			time.Sleep(time.Duration(end-from) * 100 * time.Microsecond)
+			return func() error {
+				processed = append(processed, from)
+				return nil
+			}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []uint64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}
+		if !reflect.DeepEqual(processed, want) {
+			t.Errorf("have = %v, want %v", processed, want)
+		}
+	})
+
+	t.Run("Fetches several windows at the same time", func(t *testing.T) {
+		var mu sync.Mutex
+		running, most := 0, 0
+		err := scanner.Backfill(0, &end, func(from uint64, to *uint64) (func() error, error) {
+			mu.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return func() error { return nil }, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if most < 2 || most > scanner.Workers {
+			t.Errorf("have = %d, want between %d and %d", most, 2, scanner.Workers)
+		}
+	})
+
+	t.Run("Stops before the window that can't be fetched", func(t *testing.T) {
+		var processed []uint64
+		want := errors.New("connection refused")
+		err := scanner.Backfill(0, &end, func(from uint64, to *uint64) (func() error, error) {
+			if from == 30 {
+				return nil, want
+			}
+			return func() error {
+				processed = append(processed, from)
+				return nil
+			}, nil
+		})
+		if err != want || !reflect.DeepEqual(processed, []uint64{0, 10, 20}) {
+			t.Errorf("have = %v, %v, want %v, %v", err, processed, want, []uint64{0, 10, 20})
+		}
+	})
+
+	t.Run("Splits the windows the node refuses", func(t *testing.T) {
+		var processed []uint64
+		err := scanner.Backfill(0, &end, func(from uint64, to *uint64) (func() error, error) {
+			if from == 40 && *to == 49 {
+				return nil, errors.New("query returned more than 10000 results")
+			}
+			return func() error {
+				processed = append(processed, from)
+				return nil
+			}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []uint64{0, 10, 20, 30, 40, 45, 50, 60, 70, 80, 90}
+		if !reflect.DeepEqual(processed, want) {
+			t.Errorf("have = %v, want %v", processed, want)
+		}
+	})
+}
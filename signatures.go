@@ -0,0 +1,186 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// signatureIndexBlock is the key of the last block of the side chain whose SignatureAdded
+// events are in the index
+const signatureIndexBlock = "SignatureIndex"
+
+// Signature is a signature of a withdrawal, added to the side chain wallet by a sealer
+type Signature struct {
+	V uint8       `json:"v"`
+	R common.Hash `json:"r"`
+	S common.Hash `json:"s"`
+	// Block, TxHash and Index identify the SignatureAdded event
+	Block  uint64      `json:"block"`
+	TxHash common.Hash `json:"txHash"`
+	Index  uint        `json:"index"`
+}
+
+func signaturesKey(txHash common.Hash) string {
+	return "signatures/" + txHash.Hex()
+}
+
+// GetSignatures returns the signatures of the withdrawal of txHash found in the index
+func GetSignatures(store Store, txHash common.Hash) ([]Signature, error) {
+	sigs, err := getSignatures(store, txHash)
+	return sigs, storeError("get signatures", err)
+}
+
+func getSignatures(s storeReadWriter, txHash common.Hash) ([]Signature, error) {
+	c, err := s.Get(signaturesKey(txHash))
+	if err != nil || c == nil {
+		return nil, err
+	}
+	var sigs []Signature
+	return sigs, json.Unmarshal(c, &sigs)
+}
+
+// indexSignature adds the signature of a SignatureAdded event to the index, unless it is
+// already there. The events must be indexed in the order of the chain.
+func indexSignature(store Store, event *sidechain.SideChainSignatureAdded) error {
+	sig := Signature{
+		V:      event.V,
+		R:      event.R,
+		S:      event.S,
+		Block:  event.Raw.BlockNumber,
+		TxHash: event.Raw.TxHash,
+		Index:  event.Raw.Index,
+	}
+	err := store.Update(func(tx StoreTx) error {
+		sigs, err := getSignatures(tx, event.TxHash)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, s := range sigs {
+			found = found || (s.TxHash == sig.TxHash && s.Index == sig.Index)
+		}
+		if !found {
+			c, err := json.Marshal(append(sigs, sig))
+			if err != nil {
+				return err
+			}
+			if err := tx.Put(signaturesKey(event.TxHash), c); err != nil {
+				return err
+			}
+		}
+		return advanceLastBlock(tx, signatureIndexBlock, sig.Block)
+	})
+	return storeError("index signature", err)
+}
+
+// IndexSignatures adds the SignatureAdded events of the side chain to the index, from the
+// last indexed block to end, or to the last block if end is nil. The whole history is only
+// read the first time, after that the processors keep the index up to date.
+func IndexSignatures(ctx context.Context, sc SideChainEvents, store Store, scanner *LogScanner, end *uint64) error {
+	start, err := GetLastProcessedBlock(store, signatureIndexBlock)
+	if err != nil {
+		return err
+	}
+	if end != nil && *end <= start {
+		return nil
+	}
+
+	return scanner.Backfill(start, end, func(from uint64, to *uint64) (func() error, error) {
+		events, err := sc.SignaturesAdded(&bind.FilterOpts{Start: from, End: to, Context: ctx})
+		if err != nil {
+			return nil, rpcError("filter signatures", err)
+		}
+		return func() error {
+			for _, event := range events {
+				if err := indexSignature(store, event); err != nil {
+					return err
+				}
+			}
+			if to == nil {
+				return nil
+			}
+			err := store.Update(func(tx StoreTx) error {
+				return advanceLastBlock(tx, signatureIndexBlock, *to)
+			})
+			return storeError("advance signature index", err)
+		}, nil
+	})
+}
+
+// rollbackSignatures removes from the index the signatures added after block, which a
+// reorganization dropped from the side chain. The index restarts from block, so that the
+// signatures still on the chain are indexed again.
+func rollbackSignatures(store Store, block uint64) error {
+	var keys []string
+	err := store.ForEach("signatures/", func(key string, value []byte) error {
+		var sigs []Signature
+		if err := json.Unmarshal(value, &sigs); err != nil {
+			return err
+		}
+		for _, sig := range sigs {
+			if sig.Block > block {
+				keys = append(keys, key)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return storeError("read signatures", err)
+	}
+
+	err = store.Update(func(tx StoreTx) error {
+		for _, key := range keys {
+			c, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			var sigs, kept []Signature
+			if err := json.Unmarshal(c, &sigs); err != nil {
+				return err
+			}
+			for _, sig := range sigs {
+				if sig.Block <= block {
+					kept = append(kept, sig)
+				}
+			}
+			if len(kept) == 0 {
+				err = tx.Delete(key)
+			} else if c, err = json.Marshal(kept); err == nil {
+				err = tx.Put(key, c)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		last, err := getLastBlock(tx, signatureIndexBlock)
+		if err != nil || last <= block {
+			return err
+		}
+		return putLastBlock(tx, signatureIndexBlock, block)
+	})
+	return storeError("roll back signatures", err)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"testing"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestIndexSignature(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		withdrawal := common.HexToHash("0x1")
+		event := func(block uint64, index uint) *sidechain.SideChainSignatureAdded {
+			return &sidechain.SideChainSignatureAdded{
+				TxHash: withdrawal,
+				V:      27,
+				R:      common.HexToHash("0x2"),
+				S:      common.HexToHash("0x3"),
+				Raw:    types.Log{BlockNumber: block, TxHash: common.HexToHash("0x4"), Index: index},
+			}
+		}
+
+		t.Run("Signatures are indexed by withdrawal", func(t *testing.T) {
+			indexSignature(store, event(10, 0))
+			indexSignature(store, event(12, 1))
+			sigs, err := GetSignatures(store, withdrawal)
+			if err != nil || len(sigs) != 2 {
+				t.Fatalf("have = %v, %v, want %v signatures", sigs, err, 2)
+			}
+			if sigs[1].Block != 12 || sigs[1].V != 27 || sigs[1].R != common.HexToHash("0x2") {
+				t.Errorf("have = %+v, want the second event", sigs[1])
+			}
+		})
+
+		t.Run("The same event is only indexed once", func(t *testing.T) {
+			indexSignature(store, event(12, 1))
+			sigs, _ := GetSignatures(store, withdrawal)
+			if len(sigs) != 2 {
+				t.Errorf("have = %v signatures, want %v", len(sigs), 2)
+			}
+		})
+
+		t.Run("Other withdrawals have no signatures", func(t *testing.T) {
+			sigs, err := GetSignatures(store, common.HexToHash("0x5"))
+			if err != nil || sigs != nil {
+				t.Errorf("have = %v, %v, want %v, %v", sigs, err, nil, nil)
+			}
+		})
+
+		t.Run("The index remembers the last block indexed", func(t *testing.T) {
+			indexSignature(store, event(11, 0))
+			have, _ := GetLastProcessedBlock(store, signatureIndexBlock)
+			if have != 12 {
+				t.Errorf("have = %v, want %v", have, 12)
+			}
+		})
+	})
+}
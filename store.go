@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"fmt"
+)
+
+// Store persists the state of the node, like the last processed blocks
+type Store interface {
+	// Get returns the value of key, or nil if key is not set
+	Get(key string) ([]byte, error)
+	// Put sets the value of key
+	Put(key string, value []byte) error
+	// ForEach calls fn for each key starting with prefix, in key order
+	ForEach(prefix string, fn func(key string, value []byte) error) error
+	// Update calls fn in a transaction. The changes made by fn are either all
+	// saved or, if fn returns an error, all discarded.
+	Update(fn func(tx StoreTx) error) error
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// StoreTx is a transaction of a Store
+type StoreTx interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+}
+
+// OpenStore opens a store of the given kind, either "file" or "bolt", at path
+func OpenStore(kind string, path string) (Store, error) {
+	switch kind {
+	case "file":
+		s, err := NewFileStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "bolt":
+		s, err := NewBoltStore(path + "/icn.db")
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltBucket is the bucket holding all the keys of a BoltStore
+var boltBucket = []byte("icn")
+
+// BoltStore is a Store backed by an embedded bolt database
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the bolt database at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, storeError("open store", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, storeError("open store", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Get returns the value of key, or nil if key is not set
+func (s *BoltStore) Get(key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// The value is only valid during the transaction
+		if v := tx.Bucket(boltBucket).Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Put sets the value of key
+func (s *BoltStore) Put(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+}
+
+// ForEach calls fn for each key starting with prefix, in key order. The values are read
+// first, so that fn can update the store without deadlocking bolt.
+func (s *BoltStore) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	var keys []string
+	var values [][]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			keys = append(keys, string(k))
+			values = append(values, append([]byte{}, v...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := fn(keys[i], values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update calls fn in a bolt read-write transaction
+func (s *BoltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx.Bucket(boltBucket)})
+	})
+}
+
+// Close closes the bolt database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	bucket *bolt.Bucket
+}
+
+func (tx boltTx) Get(key string) ([]byte, error) {
+	if v := tx.bucket.Get([]byte(key)); v != nil {
+		return append([]byte{}, v...), nil
+	}
+	return nil, nil
+}
+
+func (tx boltTx) Put(key string, value []byte) error {
+	return tx.bucket.Put([]byte(key), value)
+}
+
+func (tx boltTx) Delete(key string) error {
+	return tx.bucket.Delete([]byte(key))
+}
@@ -0,0 +1,252 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// journalFile lists the changes of a transaction being committed by a FileStore
+const journalFile = "journal"
+
+// FileStore is a Store that saves each key in its own plain text file, like the
+// checkpoints of the earlier versions of the node
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileStore opens a FileStore in dir, completing the transaction that was being
+// committed if the node stopped in the middle of it
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, storeError("open store", err)
+	}
+	s := &FileStore{dir: dir}
+	if err := s.replayJournal(); err != nil {
+		return nil, storeError("replay journal", err)
+	}
+	return s, nil
+}
+
+// fileChange is a change of a transaction, written to the journal before being applied
+type fileChange struct {
+	Key    string `json:"key"`
+	Delete bool   `json:"delete"`
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Get returns the content of the file of key, or nil if there is none
+func (s *FileStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key)
+}
+
+func (s *FileStore) get(key string) ([]byte, error) {
+	value, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// Put replaces the file of key
+func (s *FileStore) Put(key string, value []byte) error {
+	return s.Update(func(tx StoreTx) error {
+		return tx.Put(key, value)
+	})
+}
+
+// ForEach calls fn for each file whose key starts with prefix, in key order
+func (s *FileStore) ForEach(prefix string, fn func(key string, value []byte) error) error {
+	s.mu.Lock()
+	var keys []string
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if key != journalFile && !strings.HasSuffix(key, ".tmp") && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update calls fn and commits its changes. The new values are first written to temporary
+// files, then the journal is written, and finally the temporary files are renamed. If the
+// node stops after the journal was written, NewFileStore completes the renames.
+func (s *FileStore) Update(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &fileTx{store: s, values: map[string][]byte{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.changes) == 0 {
+		return nil
+	}
+
+	for _, change := range tx.changes {
+		if !change.Delete {
+			if err := writeFileSync(s.path(change.Key)+".tmp", tx.values[change.Key]); err != nil {
+				return err
+			}
+		}
+	}
+	journal, err := json.Marshal(tx.changes)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(s.path(journalFile)+".tmp", journal); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path(journalFile)+".tmp", s.path(journalFile)); err != nil {
+		return err
+	}
+	return s.applyJournal(tx.changes)
+}
+
+// Close does nothing, the files are closed after each operation
+func (s *FileStore) Close() error {
+	return nil
+}
+
+func (s *FileStore) replayJournal() error {
+	journal, err := ioutil.ReadFile(s.path(journalFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var changes []fileChange
+	if err := json.Unmarshal(journal, &changes); err != nil {
+		return err
+	}
+	return s.applyJournal(changes)
+}
+
+// applyJournal applies the changes of a committed transaction then deletes the journal.
+// It can be called again on changes that were already applied.
+func (s *FileStore) applyJournal(changes []fileChange) error {
+	for _, change := range changes {
+		path := s.path(change.Key)
+		var err error
+		if change.Delete {
+			err = os.Remove(path)
+		} else if _, err = os.Stat(path + ".tmp"); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Remove(s.path(journalFile))
+}
+
+// writeFileSync writes value to path and flushes it to the disk
+func writeFileSync(path string, value []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(value); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// fileTx keeps the changes of a transaction in memory until it is committed
+type fileTx struct {
+	store   *FileStore
+	values  map[string][]byte
+	changes []fileChange
+}
+
+func (tx *fileTx) Get(key string) ([]byte, error) {
+	for i := len(tx.changes) - 1; i >= 0; i-- {
+		if tx.changes[i].Key == key {
+			return tx.values[key], nil
+		}
+	}
+	return tx.store.get(key)
+}
+
+func (tx *fileTx) Put(key string, value []byte) error {
+	tx.values[key] = value
+	tx.record(fileChange{Key: key})
+	return nil
+}
+
+func (tx *fileTx) Delete(key string) error {
+	tx.values[key] = nil
+	tx.record(fileChange{Key: key, Delete: true})
+	return nil
+}
+
+// record keeps a single change per key, the last one
+func (tx *fileTx) record(change fileChange) {
+	for i := range tx.changes {
+		if tx.changes[i].Key == change.Key {
+			tx.changes[i] = change
+			return
+		}
+	}
+	tx.changes = append(tx.changes, change)
+}
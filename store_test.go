@@ -0,0 +1,130 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testStores(t *testing.T, fn func(t *testing.T, store Store)) {
+	for _, kind := range []string{"file", "bolt"} {
+		t.Run(kind, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "icn")
+			defer os.RemoveAll(dir)
+			store, err := OpenStore(kind, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			fn(t, store)
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		t.Run("Returns nil for a missing key", func(t *testing.T) {
+			have, err := store.Get("missing")
+			if err != nil || have != nil {
+				t.Errorf("have = %v, %v, want %v, %v", have, err, nil, nil)
+			}
+		})
+
+		t.Run("Returns the value of a key", func(t *testing.T) {
+			store.Put("MCDeposit", []byte("42"))
+			have, _ := store.Get("MCDeposit")
+			if string(have) != "42" {
+				t.Errorf("have = %s, want %s", have, "42")
+			}
+		})
+
+		t.Run("Saves all the keys of a transaction", func(t *testing.T) {
+			err := store.Update(func(tx StoreTx) error {
+				tx.Put("a", []byte("1"))
+				tx.Put("b", []byte("2"))
+				tx.Delete("MCDeposit")
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, _ := store.Get("a")
+			b, _ := store.Get("b")
+			c, _ := store.Get("MCDeposit")
+			if string(a) != "1" || string(b) != "2" || c != nil {
+				t.Errorf("have = %s, %s, %s, want %s, %s, %v", a, b, c, "1", "2", nil)
+			}
+		})
+
+		t.Run("Discards a failed transaction", func(t *testing.T) {
+			failure := errors.New("failure")
+			err := store.Update(func(tx StoreTx) error {
+				tx.Put("a", []byte("3"))
+				return failure
+			})
+			if err != failure {
+				t.Errorf("err = %v, want %v", err, failure)
+			}
+			have, _ := store.Get("a")
+			if string(have) != "1" {
+				t.Errorf("have = %s, want %s", have, "1")
+			}
+		})
+
+		t.Run("Iterates over the keys with a prefix in order", func(t *testing.T) {
+			store.Put("transfer/02", []byte("y"))
+			store.Put("transfer/01", []byte("x"))
+			var have []string
+			store.ForEach("transfer/", func(key string, value []byte) error {
+				have = append(have, key+"="+string(value))
+				return nil
+			})
+			want := []string{"transfer/01=x", "transfer/02=y"}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("have = %v, want %v", have, want)
+			}
+		})
+	})
+}
+
+func TestFileStoreReplaysJournal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "icn")
+	defer os.RemoveAll(dir)
+
+	// Simulate a node that stopped after writing the journal, before renaming the files
+	ioutil.WriteFile(dir+"/MCDeposit.tmp", []byte("12"), os.ModePerm)
+	ioutil.WriteFile(dir+"/journal", []byte(`[{"key":"MCDeposit","delete":false}]`), os.ModePerm)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, _ := GetLastProcessedBlock(store, "MCDeposit")
+	if have != 12 {
+		t.Errorf("have = %v, want %v", have, 12)
+	}
+	if _, err := os.Stat(dir + "/journal"); !os.IsNotExist(err) {
+		t.Errorf("journal still exists: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SubmitterOrder returns the order in which the owners submit the withdrawal of txHash to
+// the main chain. The first one is the designated submitter, the others are fallbacks.
+// Every sealer computes the same order: the owners are sorted by address, and the
+// designated submitter is picked from the hash, so that the work is spread among them.
+func SubmitterOrder(txHash common.Hash, owners []common.Address) []common.Address {
+	sorted := make([]common.Address, len(owners))
+	copy(sorted, owners)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	if len(sorted) == 0 {
+		return sorted
+	}
+
+	first := new(big.Int).Mod(new(big.Int).SetBytes(txHash[:]), big.NewInt(int64(len(sorted)))).Int64()
+	return append(sorted[first:], sorted[:first]...)
+}
+
+// submitterDelay returns how long the sealer waits, once the withdrawal of txHash has enough
+// signatures, before submitting it. The designated submitter doesn't wait, and each fallback
+// waits one more timeout than the one before it. Sealers that are not owners come last.
+func submitterDelay(ctx context.Context, sc SideChainCaller, sealerAddr common.Address,
+	txHash common.Hash, timeout time.Duration) (time.Duration, error) {
+	if timeout == 0 {
+		return 0, nil
+	}
+	owners, err := sc.GetOwners(&bind.CallOpts{Pending: false, From: sealerAddr, Context: ctx})
+	if err != nil {
+		return 0, rpcError("get owners", err)
+	}
+	order := SubmitterOrder(txHash, owners)
+	for i, owner := range order {
+		if owner == sealerAddr {
+			return time.Duration(i) * timeout, nil
+		}
+	}
+	return time.Duration(len(order)) * timeout, nil
+}
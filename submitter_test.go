@@ -0,0 +1,167 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSubmitterOrder(t *testing.T) {
+	a := common.HexToAddress("0xa")
+	b := common.HexToAddress("0xb")
+	c := common.HexToAddress("0xc")
+
+	tests := []struct {
+		name   string
+		txHash common.Hash
+		owners []common.Address
+		want   []common.Address
+	}{
+		{"First owner for a multiple of the number of owners", common.HexToHash("0x3"), []common.Address{a, b, c}, []common.Address{a, b, c}},
+		{"Rotates with the hash", common.HexToHash("0x4"), []common.Address{a, b, c}, []common.Address{b, c, a}},
+		{"Doesn't depend on the order of the owners", common.HexToHash("0x5"), []common.Address{b, a, c}, []common.Address{c, a, b}},
+		{"No owners", common.HexToHash("0x5"), []common.Address{}, []common.Address{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owners := append([]common.Address{}, tt.owners...)
+			have := SubmitterOrder(tt.txHash, tt.owners)
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("have = %v, want %v", have, tt.want)
+			}
+			if !reflect.DeepEqual(owners, tt.owners) {
+				t.Errorf("owners = %v, want %v", tt.owners, owners)
+			}
+		})
+	}
+}
+
+// ownedSideChain is a side chain wallet with the given owners, whose withdrawals hold the given signatures
+type ownedSideChain struct {
+	withdrawalSideChain
+	owners []common.Address
+}
+
+func (f *ownedSideChain) Required(opts *bind.CallOpts) (uint8, error) {
+	return uint8(len(f.owners)), nil
+}
+
+func (f *ownedSideChain) GetOwners(opts *bind.CallOpts) ([]common.Address, error) {
+	return f.owners, nil
+}
+
+func (f *ownedSideChain) IsOwner(opts *bind.CallOpts, addr common.Address) (bool, error) {
+	for _, owner := range f.owners {
+		if owner == addr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// submittingMainChain records the withdrawals submitted to a main chain wallet without executions
+type submittingMainChain struct {
+	fakeMainChain
+	submitted int
+}
+
+func (f *submittingMainChain) SubmitTransaction(opts *bind.TransactOpts, txHash [32]byte, destination common.Address, value *big.Int,
+	data []byte, v []uint8, r [][32]byte, s [][32]byte) (*types.Transaction, error) {
+	f.submitted++
+	return types.NewTransaction(0, destination, value, 0, opts.GasPrice, data), nil
+}
+
+func TestRelaySCSignatureAddedRotation(t *testing.T) {
+	wallet := common.HexToAddress("0x5c")
+	txHash := common.HexToHash("0x1")
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	msgHash := MsgHash(wallet, txHash, common.Address{}, nil, nil, 1)
+	var owners []common.Address
+	var sigs []Signature
+	for _, key := range []*ecdsa.PrivateKey{key1, key2} {
+		owners = append(owners, crypto.PubkeyToAddress(key.PublicKey))
+		v, r, s, _ := Sign(msgHash, key)
+		sigs = append(sigs, Signature{V: v, R: r, S: s})
+	}
+	order := SubmitterOrder(txHash, owners)
+	fallbackTimeout := time.Hour
+
+	relay := func(store Store, mc *submittingMainChain, sealer common.Address) (relayOutcome, error) {
+		sc := &ownedSideChain{withdrawalSideChain: withdrawalSideChain{sigs: sigs}, owners: owners}
+		for i, sig := range sigs {
+			indexSignature(store, &sidechain.SideChainSignatureAdded{TxHash: txHash, V: sig.V, R: sig.R, S: sig.S,
+				Raw: types.Log{BlockNumber: 2, TxHash: common.BigToHash(big.NewInt(int64(10 + i)))}})
+		}
+		sender := &Sender{Auth: &bind.TransactOpts{From: sealer}, Gas: GasPolicy{Mode: GasZero}}
+		mcClient := &fakeChain{heads: []int64{5}}
+		event := &sidechain.SideChainSignatureAdded{TxHash: txHash, Raw: types.Log{BlockNumber: 2}}
+		return relaySCSignatureAdded(context.Background(), sender, mc, sc, mcClient, wallet, store, nil, fallbackTimeout, event)
+	}
+
+	t.Run("The designated submitter submits at once", func(t *testing.T) {
+		testStores(t, func(t *testing.T, store Store) {
+			mc := &submittingMainChain{}
+			outcome, err := relay(store, mc, order[0])
+			if err != nil || outcome != relaySent || mc.submitted != 1 {
+				t.Errorf("have = %v, %v, %v submitted, want %v, %v, %v submitted", outcome, err, mc.submitted, relaySent, nil, 1)
+			}
+		})
+	})
+
+	t.Run("A fallback waits for the designated submitter", func(t *testing.T) {
+		testStores(t, func(t *testing.T, store Store) {
+			mc := &submittingMainChain{}
+			outcome, err := relay(store, mc, order[1])
+			if err != nil || outcome != relayWaiting || mc.submitted != 0 {
+				t.Errorf("have = %v, %v, %v submitted, want %v, %v, %v submitted", outcome, err, mc.submitted, relayWaiting, nil, 0)
+			}
+			if have, _ := GetTransfer(store, txHash); have == nil || have.ReadyAt.IsZero() {
+				t.Errorf("have = %v, want the time the withdrawal was ready", have)
+			}
+		})
+	})
+
+	t.Run("A fallback submits once the designated submitter had its turn", func(t *testing.T) {
+		testStores(t, func(t *testing.T, store Store) {
+			mc := &submittingMainChain{}
+			relay(store, mc, order[1])
+			transfer, _ := GetTransfer(store, txHash)
+			transfer.ReadyAt = time.Now().Add(-fallbackTimeout)
+			PutTransfer(store, transfer)
+
+			outcome, err := relay(store, mc, order[1])
+			if err != nil || outcome != relaySent || mc.submitted != 1 {
+				t.Errorf("have = %v, %v, %v submitted, want %v, %v, %v submitted", outcome, err, mc.submitted, relaySent, nil, 1)
+			}
+		})
+	})
+}
@@ -0,0 +1,308 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// clockSkew is how far apart the clocks of the main chain and the side chain may be
+const clockSkew = 10 * time.Minute
+
+// OwnerVote tells if an owner of the side chain wallet voted for a transfer
+type OwnerVote struct {
+	Owner common.Address `json:"owner"`
+	Voted bool           `json:"voted"`
+}
+
+// TransferTrace is a transfer as read from both chains, without the store of a node
+type TransferTrace struct {
+	Direction   string         `json:"direction"`
+	SourceTx    common.Hash    `json:"sourceTx"`
+	SourceBlock uint64         `json:"sourceBlock"`
+	Sender      common.Address `json:"sender"`
+	To          common.Address `json:"to"`
+	Value       *big.Int       `json:"value"`
+	// Required is the number of votes the transfer needs
+	Required uint8 `json:"required"`
+	// Owners are the owners of the side chain wallet, and whether they confirmed the deposit
+	// of the main chain, or signed the withdrawal from the side chain
+	Owners []OwnerVote `json:"owners"`
+	// Signatures are the SignatureAdded events of a withdrawal
+	Signatures []Signature `json:"signatures,omitempty"`
+	Executed   bool        `json:"executed"`
+	// ExecutionTx and ExecutionBlock identify the Execution event of the destination wallet
+	ExecutionTx    common.Hash `json:"executionTx,omitempty"`
+	ExecutionBlock uint64      `json:"executionBlock,omitempty"`
+}
+
+// TraceTransfer follows the transfer of the deposit sourceTx across both chains. The deposit
+// is looked up on the main chain first, then on the side chain. It returns nil if neither
+// chain has the transaction.
+func TraceTransfer(ctx context.Context, mcClient ChainReader, scClient ChainReader,
+	mc MainChainEvents, sc SideChainBackend, sideChainWalletAddress common.Address,
+	scanner *LogScanner, sourceTx common.Hash) (*TransferTrace, error) {
+	t, err := traceMCDeposit(ctx, mcClient, mc, sourceTx)
+	if err == nil && t == nil {
+		t, err = traceSCDeposit(ctx, scClient, sc, sourceTx)
+	}
+	if err != nil || t == nil {
+		return nil, err
+	}
+
+	opts := &bind.CallOpts{Pending: false, Context: ctx}
+	if t.Required, err = sc.Required(opts); err != nil {
+		return nil, rpcError("get required votes", err)
+	}
+	owners, err := sc.GetOwners(opts)
+	if err != nil {
+		return nil, rpcError("get owners", err)
+	}
+
+	if t.Direction == MainChainToSideChain {
+		err = traceMCToSC(ctx, mcClient, scClient, sc, scanner, owners, t)
+	} else {
+		err = traceSCToMC(ctx, scClient, mcClient, mc, sc, sideChainWalletAddress, scanner, owners, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// depositBlock returns the block of the transaction txHash, nil if the chain doesn't have it
+func depositBlock(ctx context.Context, client ChainReader, txHash common.Hash) (*uint64, error) {
+	receipt, err := client.TransactionReceipt(ctx, txHash)
+	if err == ethereum.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, rpcError("get receipt", err)
+	}
+	block := receipt.BlockNumber.Uint64()
+	return &block, nil
+}
+
+func traceMCDeposit(ctx context.Context, client ChainReader, mc MainChainEvents, txHash common.Hash) (*TransferTrace, error) {
+	block, err := depositBlock(ctx, client, txHash)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	events, err := mc.Deposits(&bind.FilterOpts{Start: *block, End: block, Context: ctx})
+	if err != nil {
+		return nil, rpcError("filter deposits", err)
+	}
+	for _, event := range events {
+		if event.Raw.TxHash == txHash {
+			return &TransferTrace{
+				Direction:   MainChainToSideChain,
+				SourceTx:    txHash,
+				SourceBlock: event.Raw.BlockNumber,
+				Sender:      event.Sender,
+				To:          event.To,
+				Value:       event.Value,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a deposit to the main chain wallet", txHash.Hex())
+}
+
+func traceSCDeposit(ctx context.Context, client ChainReader, sc SideChainEvents, txHash common.Hash) (*TransferTrace, error) {
+	block, err := depositBlock(ctx, client, txHash)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	events, err := sc.Deposits(&bind.FilterOpts{Start: *block, End: block, Context: ctx})
+	if err != nil {
+		return nil, rpcError("filter deposits", err)
+	}
+	for _, event := range events {
+		if event.Raw.TxHash == txHash {
+			return &TransferTrace{
+				Direction:   SideChainToMainChain,
+				SourceTx:    txHash,
+				SourceBlock: event.Raw.BlockNumber,
+				Sender:      event.Sender,
+				To:          event.To,
+				Value:       event.Value,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a deposit to the side chain wallet", txHash.Hex())
+}
+
+// executionStart returns the first block of the destination chain that may hold the execution
+// of a transfer, the first one mined after its deposit, and the head of the destination chain.
+// The clocks of the chains may disagree by up to clockSkew.
+func executionStart(ctx context.Context, source HeadReader, dest HeadReader, t *TransferTrace) (uint64, uint64, error) {
+	deposit, err := source.HeaderByNumber(ctx, new(big.Int).SetUint64(t.SourceBlock))
+	if err != nil {
+		return 0, 0, rpcError("get header", err)
+	}
+	head, err := headNumber(ctx, dest)
+	if err != nil {
+		return 0, 0, err
+	}
+	since := new(big.Int).Sub(deposit.Time, big.NewInt(int64(clockSkew/time.Second)))
+	// Find the first block of the destination chain mined after since
+	low, high := uint64(0), head
+	for low < high {
+		mid := low + (high-low)/2
+		header, err := dest.HeaderByNumber(ctx, new(big.Int).SetUint64(mid))
+		if err != nil {
+			return 0, 0, rpcError("get header", err)
+		}
+		if header.Time.Cmp(since) < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, head, nil
+}
+
+// ownerVotes reads whether each of the owners confirmed the deposit txHash of the main chain
+func ownerVotes(opts *bind.CallOpts, sc SideChainCaller, owners []common.Address, txHash common.Hash) ([]OwnerVote, error) {
+	var votes []OwnerVote
+	for _, owner := range owners {
+		confirmed, err := sc.Confirmations(opts, txHash, owner)
+		if err != nil {
+			return nil, rpcError("get confirmation", err)
+		}
+		votes = append(votes, OwnerVote{Owner: owner, Voted: confirmed})
+	}
+	return votes, nil
+}
+
+// traceMCToSC reads the confirmations of a deposit of the main chain, and its execution
+// on the side chain
+func traceMCToSC(ctx context.Context, mcClient HeadReader, scClient HeadReader,
+	sc SideChainBackend, scanner *LogScanner, owners []common.Address, t *TransferTrace) error {
+	opts := &bind.CallOpts{Pending: false, Context: ctx}
+	votes, err := ownerVotes(opts, sc, owners, t.SourceTx)
+	if err != nil {
+		return err
+	}
+	t.Owners = votes
+
+	executed, err := sc.IsConfirmed(opts, t.SourceTx)
+	if err != nil {
+		return rpcError("check confirmed", err)
+	}
+	if !executed {
+		return nil
+	}
+	t.Executed = true
+	start, head, err := executionStart(ctx, mcClient, scClient, t)
+	if err != nil {
+		return err
+	}
+	return scanner.Scan(start, &head, func(from uint64, to *uint64) error {
+		if t.ExecutionTx != (common.Hash{}) {
+			return nil
+		}
+		events, err := sc.Executions(&bind.FilterOpts{Start: from, End: to, Context: ctx}, [][32]byte{t.SourceTx})
+		if err != nil {
+			return rpcError("filter executions", err)
+		}
+		if len(events) > 0 {
+			t.ExecutionTx, t.ExecutionBlock = events[0].Raw.TxHash, events[0].Raw.BlockNumber
+		}
+		return nil
+	})
+}
+
+// traceSCToMC reads the signatures of a withdrawal from the side chain, both those stored
+// in the wallet and the SignatureAdded events since the deposit, and its execution on the
+// main chain
+func traceSCToMC(ctx context.Context, scClient HeadReader, mcClient HeadReader, mc MainChainEvents, sc SideChainBackend,
+	sideChainWalletAddress common.Address, scanner *LogScanner, owners []common.Address, t *TransferTrace) error {
+	resp, err := sc.GetTransactionMC(&bind.CallOpts{Pending: false, Context: ctx}, t.SourceTx)
+	if err != nil {
+		return rpcError("get withdrawal", err)
+	}
+	msgHash := MsgHash(sideChainWalletAddress, t.SourceTx, t.To, t.Value, resp.Data, 1)
+	signed := make(map[common.Address]bool)
+	for i := range resp.V {
+		if signer, err := RecoverSigner(msgHash, resp.V[i], resp.R[i], resp.S[i]); err == nil {
+			signed[signer] = true
+		}
+	}
+
+	head, err := headNumber(ctx, scClient)
+	if err != nil {
+		return err
+	}
+	err = scanner.Scan(t.SourceBlock, &head, func(from uint64, to *uint64) error {
+		events, err := sc.SignaturesAdded(&bind.FilterOpts{Start: from, End: to, Context: ctx})
+		if err != nil {
+			return rpcError("filter signatures", err)
+		}
+		for _, event := range events {
+			if common.Hash(event.TxHash) != t.SourceTx {
+				continue
+			}
+			t.Signatures = append(t.Signatures, Signature{
+				V:      event.V,
+				R:      event.R,
+				S:      event.S,
+				Block:  event.Raw.BlockNumber,
+				TxHash: event.Raw.TxHash,
+				Index:  event.Raw.Index,
+			})
+			if signer, err := RecoverSigner(msgHash, event.V, event.R, event.S); err == nil {
+				signed[signer] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		t.Owners = append(t.Owners, OwnerVote{Owner: owner, Voted: signed[owner]})
+	}
+
+	start, mcHead, err := executionStart(ctx, scClient, mcClient, t)
+	if err != nil {
+		return err
+	}
+	return scanner.Scan(start, &mcHead, func(from uint64, to *uint64) error {
+		if t.Executed {
+			return nil
+		}
+		events, err := mc.Executions(&bind.FilterOpts{Start: from, End: to, Context: ctx}, [][32]byte{t.SourceTx})
+		if err != nil {
+			return rpcError("filter executions", err)
+		}
+		if len(events) > 0 {
+			t.Executed = true
+			t.ExecutionTx, t.ExecutionBlock = events[0].Raw.TxHash, events[0].Raw.BlockNumber
+		}
+		return nil
+	})
+}
@@ -0,0 +1,207 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// traceSideChain is a side chain wallet with the given owners, confirmations and events
+type traceSideChain struct {
+	fakeSideChain
+	owners     []common.Address
+	confirmed  map[common.Address]bool
+	deposits   []*sidechain.SideChainDeposit
+	executions []*sidechain.SideChainExecution
+}
+
+func (f *traceSideChain) Required(opts *bind.CallOpts) (uint8, error) {
+	return 2, nil
+}
+
+func (f *traceSideChain) GetOwners(opts *bind.CallOpts) ([]common.Address, error) {
+	return f.owners, nil
+}
+
+func (f *traceSideChain) Confirmations(opts *bind.CallOpts, txHash [32]byte, owner common.Address) (bool, error) {
+	return f.confirmed[owner], nil
+}
+
+func (f *traceSideChain) IsConfirmed(opts *bind.CallOpts, txHash [32]byte) (bool, error) {
+	return len(f.executions) > 0, nil
+}
+
+func (f *traceSideChain) GetTransactionMC(opts *bind.CallOpts, txHash [32]byte) (struct {
+	Destination common.Address
+	Value       *big.Int
+	Data        []byte
+	V           []uint8
+	R           [][32]byte
+	S           [][32]byte
+}, error) {
+	var resp struct {
+		Destination common.Address
+		Value       *big.Int
+		Data        []byte
+		V           []uint8
+		R           [][32]byte
+		S           [][32]byte
+	}
+	return resp, nil
+}
+
+func (f *traceSideChain) Deposits(opts *bind.FilterOpts) ([]*sidechain.SideChainDeposit, error) {
+	return f.deposits, nil
+}
+
+func (f *traceSideChain) Executions(opts *bind.FilterOpts, txHash [][32]byte) ([]*sidechain.SideChainExecution, error) {
+	var events []*sidechain.SideChainExecution
+	for _, event := range f.executions {
+		if event.Raw.BlockNumber >= opts.Start && event.Raw.BlockNumber <= *opts.End {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// chainHeaders returns the headers of a chain from its genesis to head, mined every 15 seconds
+func chainHeaders(head int64) map[uint64]*types.Header {
+	headers := map[uint64]*types.Header{}
+	for n := int64(0); n <= head; n++ {
+		headers[uint64(n)] = &types.Header{Number: big.NewInt(n), Time: big.NewInt(1500000000 + 15*n)}
+	}
+	return headers
+}
+
+func TestTraceTransfer(t *testing.T) {
+	ctx := context.Background()
+	alice, _ := crypto.GenerateKey()
+	bob, _ := crypto.GenerateKey()
+	owners := []common.Address{crypto.PubkeyToAddress(alice.PublicKey), crypto.PubkeyToAddress(bob.PublicKey)}
+	scWallet := common.HexToAddress("0x5c")
+	to := common.HexToAddress("0x70")
+	value := big.NewInt(1000)
+
+	// A deposit of the main chain in block 3, confirmed by alice and executed on the side chain
+	mcTx := common.HexToHash("0x01")
+	mcClient := &fakeChain{headers: chainHeaders(8), receipts: map[common.Hash]*types.Receipt{mcTx: {BlockNumber: big.NewInt(3)}}}
+	mc := &fakeMainChain{deposits: []*mainchain.MainChainDeposit{
+		{To: to, Value: value, Raw: types.Log{BlockNumber: 3, TxHash: common.HexToHash("0x0f")}},
+		{To: to, Value: value, Raw: types.Log{BlockNumber: 3, TxHash: mcTx}},
+	}}
+
+	// A deposit of the side chain in block 2, signed by bob in block 4
+	scTx := common.HexToHash("0x02")
+	scClient := &fakeChain{headers: chainHeaders(8), receipts: map[common.Hash]*types.Receipt{scTx: {BlockNumber: big.NewInt(2)}}}
+	v, r, s, _ := Sign(MsgHash(scWallet, scTx, to, value, nil, 1), bob)
+	sc := &traceSideChain{
+		fakeSideChain: fakeSideChain{signatures: []*sidechain.SideChainSignatureAdded{
+			{TxHash: common.HexToHash("0x0f"), V: v, R: r, S: s, Raw: types.Log{BlockNumber: 3}},
+			{TxHash: scTx, V: v, R: r, S: s, Raw: types.Log{BlockNumber: 4, TxHash: common.HexToHash("0x04")}},
+		}},
+		owners:    owners,
+		confirmed: map[common.Address]bool{owners[0]: true},
+		deposits:  []*sidechain.SideChainDeposit{{To: to, Value: value, Raw: types.Log{BlockNumber: 2, TxHash: scTx}}},
+		executions: []*sidechain.SideChainExecution{
+			{TxHash: mcTx, Raw: types.Log{BlockNumber: 7, TxHash: common.HexToHash("0x07")}},
+		},
+	}
+
+	t.Run("Deposit of the main chain", func(t *testing.T) {
+		have, err := TraceTransfer(ctx, mcClient, scClient, mc, sc, scWallet, nil, mcTx)
+		want := &TransferTrace{
+			Direction:      MainChainToSideChain,
+			SourceTx:       mcTx,
+			SourceBlock:    3,
+			To:             to,
+			Value:          value,
+			Required:       2,
+			Owners:         []OwnerVote{{owners[0], true}, {owners[1], false}},
+			Executed:       true,
+			ExecutionTx:    common.HexToHash("0x07"),
+			ExecutionBlock: 7,
+		}
+		if err != nil || !reflect.DeepEqual(have, want) {
+			t.Errorf("have = %+v, %v, want %+v", have, err, want)
+		}
+	})
+
+	t.Run("Deposit of the side chain", func(t *testing.T) {
+		have, err := TraceTransfer(ctx, mcClient, scClient, mc, sc, scWallet, &LogScanner{Window: 2}, scTx)
+		want := &TransferTrace{
+			Direction:   SideChainToMainChain,
+			SourceTx:    scTx,
+			SourceBlock: 2,
+			To:          to,
+			Value:       value,
+			Required:    2,
+			Owners:      []OwnerVote{{owners[0], false}, {owners[1], true}},
+			Signatures:  []Signature{{V: v, R: r, S: s, Block: 4, TxHash: common.HexToHash("0x04")}},
+		}
+		if err != nil || !reflect.DeepEqual(have, want) {
+			t.Errorf("have = %+v, %v, want %+v", have, err, want)
+		}
+	})
+
+	t.Run("Unknown transaction", func(t *testing.T) {
+		have, err := TraceTransfer(ctx, mcClient, scClient, mc, sc, scWallet, nil, common.HexToHash("0x42"))
+		if have != nil || err != nil {
+			t.Errorf("have = %v, %v, want %v, %v", have, err, nil, nil)
+		}
+	})
+
+	t.Run("Not a deposit", func(t *testing.T) {
+		mcClient.receipts[common.HexToHash("0x43")] = &types.Receipt{BlockNumber: big.NewInt(3)}
+		have, err := TraceTransfer(ctx, mcClient, scClient, mc, sc, scWallet, nil, common.HexToHash("0x43"))
+		if have != nil || err == nil {
+			t.Errorf("have = %v, %v, want an error", have, err)
+		}
+	})
+}
+
+func TestExecutionStart(t *testing.T) {
+	chain := &fakeChain{headers: chainHeaders(200)}
+	tests := []struct {
+		name  string
+		block uint64
+		want  uint64
+	}{
+		{"Deposit less than the clock skew after genesis", 30, 0},
+		{"Deposit long after genesis", 150, 110},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, head, err := executionStart(context.Background(), chain, chain, &TransferTrace{SourceBlock: tt.block})
+			if err != nil || start != tt.want || head != 200 {
+				t.Errorf("have = %v, %v, %v, want %v, %v", start, head, err, tt.want, 200)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Directions of a transfer
+const (
+	MainChainToSideChain = "mc2sc"
+	SideChainToMainChain = "sc2mc"
+)
+
+// TransferState is a step in the life of a transfer
+type TransferState string
+
+// States of a transfer
+const (
+	// TransferObserved means the deposit was seen on the source chain
+	TransferObserved TransferState = "observed"
+	// TransferConfirmed means the deposit has enough confirmations on the source chain
+	TransferConfirmed TransferState = "confirmed"
+	// TransferVoted means the sealer sent its vote, or its signature, for the transfer
+	TransferVoted TransferState = "voted"
+	// TransferSubmitted means the sealer sent the withdrawal to the main chain
+	TransferSubmitted TransferState = "submitted"
+	// TransferMined means the last transaction sent by the sealer was mined
+	TransferMined TransferState = "mined"
+	// TransferExecuted means the transfer was executed on the destination chain
+	TransferExecuted TransferState = "executed"
+	// TransferFailed means the last transaction of the sealer couldn't be sent or reverted
+	TransferFailed TransferState = "failed"
+	// TransferRemoved means the deposit was dropped from the source chain by a reorganization
+	TransferRemoved TransferState = "removed"
+)
+
+// transferTransitions lists the states a transfer can move to from each state.
+// Votes and withdrawals can be sent again, replacing the previous transaction.
+// A removed deposit is confirmed again if it is mined again.
+var transferTransitions = map[TransferState][]TransferState{
+	TransferObserved:  {TransferConfirmed, TransferFailed, TransferRemoved},
+	TransferConfirmed: {TransferVoted, TransferSubmitted, TransferExecuted, TransferFailed, TransferRemoved},
+	TransferVoted:     {TransferVoted, TransferSubmitted, TransferMined, TransferExecuted, TransferFailed, TransferRemoved},
+	TransferSubmitted: {TransferSubmitted, TransferMined, TransferExecuted, TransferFailed, TransferRemoved},
+	TransferMined:     {TransferSubmitted, TransferExecuted, TransferFailed, TransferRemoved},
+	TransferFailed:    {TransferVoted, TransferSubmitted, TransferExecuted, TransferFailed, TransferRemoved},
+	TransferRemoved:   {TransferConfirmed},
+	TransferExecuted:  {},
+}
+
+// TransferStep is a state reached by a transfer
+type TransferStep struct {
+	State  TransferState `json:"state"`
+	Time   time.Time     `json:"time"`
+	TxHash common.Hash   `json:"txHash,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// Transfer is the record of a deposit relayed by the node, identified by the hash of
+// the deposit transaction on the source chain
+type Transfer struct {
+	Direction   string         `json:"direction"`
+	SourceTx    common.Hash    `json:"sourceTx"`
+	SourceBlock uint64         `json:"sourceBlock"`
+	To          common.Address `json:"to"`
+	Value       *big.Int       `json:"value"`
+	State       TransferState  `json:"state"`
+	// DestTx is the last transaction sent by the sealer for this transfer
+	DestTx common.Hash `json:"destTx,omitempty"`
+	// ReadyAt is when the withdrawal was first seen with enough signatures, for the
+	// transfers from the side chain that the sealer didn't submit right away
+	ReadyAt time.Time      `json:"readyAt,omitempty"`
+	Steps   []TransferStep `json:"steps"`
+	// unobserved are the steps reached since the record was last saved, counted in the
+	// metrics once it is
+	unobserved []observation
+}
+
+// Advance moves the transfer to state, recording the transaction sent or the error
+func (t *Transfer) Advance(state TransferState, txHash common.Hash, err error) error {
+	allowed := false
+	for _, next := range transferTransitions[t.State] {
+		allowed = allowed || next == state
+	}
+	if !allowed {
+		return fmt.Errorf("transfer %s can't go from %s to %s", t.SourceTx.Hex(), t.State, state)
+	}
+
+	step := TransferStep{State: state, Time: time.Now(), TxHash: txHash}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	if txHash != (common.Hash{}) {
+		t.DestTx = txHash
+	}
+	t.State = state
+	t.Steps = append(t.Steps, step)
+	t.unobserved = append(t.unobserved, observation{index: len(t.Steps) - 1, err: err})
+	return nil
+}
+
+// sent records the transaction sent for the transfer, or the error that prevented it
+func (t *Transfer) sent(state TransferState, tx *types.Transaction, err error) error {
+	if err != nil {
+		return t.Advance(TransferFailed, common.Hash{}, err)
+	}
+	return t.Advance(state, tx.Hash(), nil)
+}
+
+func transferKey(sourceTx common.Hash) string {
+	return "transfer/" + sourceTx.Hex()
+}
+
+// GetTransfer returns the record of the transfer of sourceTx, or nil if there is none
+func GetTransfer(store Store, sourceTx common.Hash) (*Transfer, error) {
+	c, err := store.Get(transferKey(sourceTx))
+	if err != nil || c == nil {
+		return nil, storeError("get transfer", err)
+	}
+	var t Transfer
+	if err := json.Unmarshal(c, &t); err != nil {
+		return nil, storeError("get transfer", err)
+	}
+	return &t, nil
+}
+
+// PutTransfer saves the record of a transfer
+func PutTransfer(store Store, t *Transfer) error {
+	c, err := json.Marshal(t)
+	if err != nil {
+		return storeError("put transfer", err)
+	}
+	if err := store.Put(transferKey(t.SourceTx), c); err != nil {
+		return storeError("put transfer", err)
+	}
+	observeSteps(t)
+	return nil
+}
+
+// ForEachTransfer calls fn with each transfer recorded in the store. The errors returned
+// by fn are returned as they are.
+func ForEachTransfer(store Store, fn func(t *Transfer) error) error {
+	var fnErr error
+	err := store.ForEach("transfer/", func(key string, value []byte) error {
+		var t Transfer
+		if err := json.Unmarshal(value, &t); err != nil {
+			return err
+		}
+		fnErr = fn(&t)
+		return fnErr
+	})
+	if err != nil && err == fnErr {
+		return err
+	}
+	return storeError("read transfers", err)
+}
+
+// observeTransfer returns the record of a deposit that reached enough confirmations on the
+// source chain, creating it if this is the first time it is seen. A deposit removed by a
+// reorganization is confirmed again when it is seen in a block again.
+func observeTransfer(store Store, direction string, raw types.Log, to common.Address, value *big.Int) (*Transfer, error) {
+	t, err := GetTransfer(store, raw.TxHash)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil && t.State == TransferRemoved && raw.BlockNumber != 0 {
+		t.SourceBlock = raw.BlockNumber
+		if err := t.Advance(TransferConfirmed, common.Hash{}, nil); err != nil {
+			return nil, err
+		}
+		return t, PutTransfer(store, t)
+	}
+	if t != nil {
+		return t, nil
+	}
+	t = &Transfer{
+		Direction:   direction,
+		SourceTx:    raw.TxHash,
+		SourceBlock: raw.BlockNumber,
+		To:          to,
+		Value:       value,
+		State:       TransferObserved,
+		Steps:       []TransferStep{{State: TransferObserved, Time: time.Now()}},
+	}
+	if err := t.Advance(TransferConfirmed, common.Hash{}, nil); err != nil {
+		return nil, err
+	}
+	return t, PutTransfer(store, t)
+}
+
+// saveTransfer records the transaction sent for a transfer, logging the errors
+func saveTransfer(store Store, logger *Logger, t *Transfer, state TransferState, tx *types.Transaction, err error) {
+	if err := t.sent(state, tx, err); err != nil {
+		logger.WithError(err).Error("can't record the transaction")
+		return
+	}
+	if err := PutTransfer(store, t); err != nil {
+		logger.WithError(err).Error("can't record the transaction")
+	}
+}
+
+// skipTransfer records the state found on chain for a transfer that needed no transaction.
+// The record is left alone if it is already further along.
+func skipTransfer(store Store, logger *Logger, t *Transfer, state TransferState) {
+	if t.State == state || t.Advance(state, common.Hash{}, nil) != nil {
+		return
+	}
+	if err := PutTransfer(store, t); err != nil {
+		logger.WithError(err).Error("can't record the transfer")
+	}
+}
+
+// ResumeTransfers moves forward the transfers that are part-way through. The votes and
+// signatures that were never sent are sent again, and the transactions already sent are
+// checked to find out if they were mined and if the transfers were executed. Withdrawals
+// whose signatures are all mined are submitted once they have enough signatures.
+// Nothing is sent for the deposits no longer on their chain, they are removed instead.
+// A transfer that can't be moved forward is logged and tried again the next time.
+func ResumeTransfers(ctx context.Context, mcSender *Sender, scSender *Sender,
+	mc MainChainBackend, sc SideChainBackend,
+	mcClient ChainReader, scClient ChainReader,
+	addr common.Address, key *ecdsa.PrivateKey, store Store, scanner *LogScanner, fallbackTimeout time.Duration) error {
+	return ForEachTransfer(store, func(t *Transfer) error {
+		err := resumeTransfer(ctx, mcSender, scSender, mc, sc, mcClient, scClient, addr, key, store, scanner, fallbackTimeout, t)
+		if err != nil {
+			// The other transfers are resumed anyway, this one is tried again next time
+			transferLogger(t.Direction, t.SourceTx, t.SourceBlock).WithError(err).Error("can't resume")
+		}
+		return ctx.Err()
+	})
+}
+
+// resumeTransfer moves forward a single transfer, see ResumeTransfers
+func resumeTransfer(ctx context.Context, mcSender *Sender, scSender *Sender,
+	mc MainChainBackend, sc SideChainBackend,
+	mcClient ChainReader, scClient ChainReader,
+	addr common.Address, key *ecdsa.PrivateKey, store Store, scanner *LogScanner, fallbackTimeout time.Duration, t *Transfer) error {
+	switch t.State {
+	case TransferObserved, TransferConfirmed:
+		removed, err := depositRemoved(ctx, sourceClient(t.Direction, mcClient, scClient), store, t.SourceTx)
+		if err != nil || removed {
+			return err
+		}
+		transferLogger(t.Direction, t.SourceTx, t.SourceBlock).Info("resuming")
+		raw := types.Log{TxHash: t.SourceTx, BlockNumber: t.SourceBlock}
+		if t.Direction == MainChainToSideChain {
+			_, err = relayMCDeposit(ctx, scSender, sc, store, &mainchain.MainChainDeposit{To: t.To, Value: t.Value, Raw: raw})
+		} else {
+			_, err = relaySCDeposit(ctx, scSender, mc, sc, mcClient, addr, key, store, scanner, &sidechain.SideChainDeposit{To: t.To, Value: t.Value, Raw: raw})
+		}
+		return err
+	case TransferVoted, TransferSubmitted:
+		// A vote or a signature found on chain wasn't sent by this run of the node
+		if t.State == TransferVoted && t.DestTx == (common.Hash{}) {
+			if err := t.Advance(TransferMined, common.Hash{}, nil); err != nil {
+				return err
+			}
+			return PutTransfer(store, t)
+		}
+		// Only the withdrawals are sent to the main chain
+		client, chain := scClient, sideChainLabel
+		if t.State == TransferSubmitted {
+			client, chain = mcClient, mainChainLabel
+		}
+		receipt, err := client.TransactionReceipt(ctx, t.DestTx)
+		if err == ethereum.NotFound {
+			return nil
+		}
+		if err != nil {
+			return rpcError("get receipt", err)
+		}
+		gasUsed.WithLabelValues(chain).Add(float64(receipt.GasUsed))
+		if receipt.Status == types.ReceiptStatusFailed {
+			err = ErrRevert
+		}
+		if err := t.Advance(minedState(t, err), common.Hash{}, err); err != nil {
+			return err
+		}
+		return PutTransfer(store, t)
+	case TransferMined:
+		executed, err := transferExecuted(ctx, mc, sc, mcClient, store, scanner, scSender.Auth.From, t)
+		if err != nil {
+			return err
+		}
+		// The owners or the number of signatures required may have changed since the
+		// last signature was added
+		if !executed && t.Direction == SideChainToMainChain {
+			removed, err := depositRemoved(ctx, scClient, store, t.SourceTx)
+			if err != nil || removed {
+				return err
+			}
+			_, err = relaySCSignatureAdded(ctx, mcSender, mc, sc, mcClient, addr, store, scanner, fallbackTimeout, &sidechain.SideChainSignatureAdded{TxHash: t.SourceTx})
+			return err
+		}
+		if !executed {
+			return nil
+		}
+		if err := t.Advance(TransferExecuted, common.Hash{}, nil); err != nil {
+			return err
+		}
+		return PutTransfer(store, t)
+	}
+	return nil
+}
+
+// sourceClient returns the client of the chain the deposits of direction are made on
+func sourceClient(direction string, mcClient ChainReader, scClient ChainReader) ChainReader {
+	if direction == MainChainToSideChain {
+		return mcClient
+	}
+	return scClient
+}
+
+// minedState returns the state of a transfer whose last transaction was mined
+func minedState(t *Transfer, err error) TransferState {
+	if err != nil {
+		return TransferFailed
+	}
+	// A withdrawal executes the transfer when it succeeds
+	if t.State == TransferSubmitted {
+		return TransferExecuted
+	}
+	return TransferMined
+}
+
+// transferExecuted checks on the destination chain if a transfer was executed
+func transferExecuted(ctx context.Context, mc MainChainBackend, sc SideChainBackend, mcClient HeadReader,
+	store Store, scanner *LogScanner, from common.Address, t *Transfer) (bool, error) {
+	if t.Direction == MainChainToSideChain {
+		executed, err := sc.IsConfirmed(&bind.CallOpts{Pending: false, From: from, Context: ctx}, t.SourceTx)
+		return executed, rpcError("check execution", err)
+	}
+	return ExecutedMC(ctx, mcClient, mc, store, scanner, t.SourceTx)
+}
+
+// ExecutedMC checks if the withdrawal of txHash was executed on the main chain. The index of
+// the executions is brought up to the head of the main chain first, see IndexExecutions.
+func ExecutedMC(ctx context.Context, mcClient HeadReader, mc MainChainEvents,
+	store Store, scanner *LogScanner, txHash common.Hash) (bool, error) {
+	head, err := headNumber(ctx, mcClient)
+	if err != nil {
+		return false, err
+	}
+	if err := IndexExecutions(ctx, mc, store, scanner, head); err != nil {
+		return false, err
+	}
+	e, err := GetExecution(store, txHash)
+	return e != nil, err
+}
+
+// WatchTransfers syncs the nonces of the senders, and calls ResumeTransfers,
+// ReplaceStuckTransactions and ProcessRetries every interval until ctx is cancelled.
+// Stuck transactions are not replaced if stuckBlocks is 0.
+func WatchTransfers(ctx context.Context, mcSender *Sender, scSender *Sender,
+	mc MainChainBackend, sc SideChainBackend,
+	mcClient PendingReader, scClient PendingReader,
+	addr common.Address, key *ecdsa.PrivateKey, store Store, scanner *LogScanner, fallbackTimeout time.Duration,
+	policy RetryPolicy, stuckBlocks uint64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, sender := range []*Sender{mcSender, scSender} {
+				if err := sender.SyncNonce(ctx); err != nil {
+					componentLogger("nonce").WithError(err).Error("can't sync the nonce")
+				}
+			}
+			if err := ResumeTransfers(ctx, mcSender, scSender, mc, sc, mcClient, scClient, addr, key, store, scanner, fallbackTimeout); err != nil {
+				componentLogger("transfers").WithError(err).Error("can't resume the transfers")
+			}
+			if stuckBlocks > 0 {
+				if err := ReplaceStuckTransactions(ctx, mcSender, scSender, mcClient, scClient, store, stuckBlocks); err != nil {
+					componentLogger("gas").WithError(err).Error("can't replace the stuck transactions")
+				}
+			}
+			if err := ProcessRetries(ctx, mcSender, scSender, mc, sc, mcClient, scClient, addr, key, store, scanner, fallbackTimeout, policy); err != nil {
+				componentLogger("retry").WithError(err).Error("can't process the retries")
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestTransferAdvance(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    TransferState
+		to      TransferState
+		wantErr bool
+	}{
+		{name: "Confirmed transfers can be voted", from: TransferConfirmed, to: TransferVoted},
+		{name: "Votes can be sent again", from: TransferVoted, to: TransferVoted},
+		{name: "Failed transfers can be voted again", from: TransferFailed, to: TransferVoted},
+		{name: "Observed transfers can't be voted", from: TransferObserved, to: TransferVoted, wantErr: true},
+		{name: "Executed transfers are final", from: TransferExecuted, to: TransferVoted, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfer := &Transfer{State: tt.from}
+			err := transfer.Advance(tt.to, common.HexToHash("01"), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Advance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (transfer.State != tt.to || transfer.DestTx != common.HexToHash("01") || len(transfer.Steps) != 1) {
+				t.Errorf("Advance() = %+v", transfer)
+			}
+		})
+	}
+}
+
+func TestTransferRecord(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "icn")
+	defer os.RemoveAll(dir)
+	store, _ := NewFileStore(dir)
+
+	raw := types.Log{TxHash: common.HexToHash("aa"), BlockNumber: 7}
+	to := common.HexToAddress("f17f52151ebef6c7334fad080c5704d77216b732")
+	transfer, err := observeTransfer(store, MainChainToSideChain, raw, to, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTransfer(store, std, transfer, TransferVoted, nil, errors.New("insufficient funds"))
+
+	t.Run("Survives in the store", func(t *testing.T) {
+		have, err := GetTransfer(store, raw.TxHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var states []TransferState
+		for _, step := range have.Steps {
+			states = append(states, step.State)
+		}
+		want := []TransferState{TransferObserved, TransferConfirmed, TransferFailed}
+		if !reflect.DeepEqual(states, want) {
+			t.Errorf("have = %v, want %v", states, want)
+		}
+		if have.State != TransferFailed || have.Steps[2].Error != "insufficient funds" {
+			t.Errorf("have = %+v", have)
+		}
+		if have.To != to || have.Value.Cmp(big.NewInt(100)) != 0 || have.SourceBlock != 7 {
+			t.Errorf("have = %+v", have)
+		}
+	})
+
+	t.Run("Is observed only once", func(t *testing.T) {
+		have, _ := observeTransfer(store, MainChainToSideChain, raw, to, big.NewInt(100))
+		if have.State != TransferFailed {
+			t.Errorf("have = %v, want %v", have.State, TransferFailed)
+		}
+	})
+
+	t.Run("Returns nil for unknown transfers", func(t *testing.T) {
+		have, err := GetTransfer(store, common.HexToHash("bb"))
+		if have != nil || err != nil {
+			t.Errorf("have = %v, %v, want %v, %v", have, err, nil, nil)
+		}
+	})
+}
+
+// flakyChain fails to return the receipt of one transaction
+type flakyChain struct {
+	*fakeChain
+	failing common.Hash
+}
+
+func (f *flakyChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if txHash == f.failing {
+		return nil, errors.New("connection reset by peer")
+	}
+	return f.fakeChain.TransactionReceipt(ctx, txHash)
+}
+
+func TestResumeTransfersFailure(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		vote := func(sourceTx string, destTx string) *Transfer {
+			return &Transfer{
+				Direction: MainChainToSideChain,
+				SourceTx:  common.HexToHash(sourceTx),
+				State:     TransferVoted,
+				DestTx:    common.HexToHash(destTx),
+			}
+		}
+		flaky, mined := vote("0x1", "0xa"), vote("0x2", "0xb")
+		PutTransfer(store, flaky)
+		PutTransfer(store, mined)
+		sc := &flakyChain{
+			fakeChain: &fakeChain{receipts: map[common.Hash]*types.Receipt{mined.DestTx: {Status: types.ReceiptStatusSuccessful}}},
+			failing:   flaky.DestTx,
+		}
+
+		// The transfer whose receipt can't be read doesn't hold back the others
+		err := ResumeTransfers(context.Background(), nil, nil, nil, nil, nil, sc, common.Address{}, nil, store, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, _ := GetTransfer(store, mined.SourceTx)
+		if have.State != TransferMined {
+			t.Errorf("have = %v, want %v", have.State, TransferMined)
+		}
+		if have, _ := GetTransfer(store, flaky.SourceTx); have.State != TransferVoted {
+			t.Errorf("have = %v, want %v", have.State, TransferVoted)
+		}
+	})
+}
@@ -0,0 +1,349 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/mainchain"
+	"github.com/WeTrustPlatform/poa-interchain-node/bind/sidechain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var errLogRemoved = errors.New("event log removed by a chain reorganization")
+
+// HeadReader is the part of ethclient.Client needed to follow the head of a chain
+type HeadReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// WatchMCDeposits processes the main chain deposits from the last checkpoint, then subscribes
+// to new deposits and relays them as they arrive until ctx is cancelled.
+// If events need confirmations, or if the endpoint doesn't support subscriptions,
+// the chain head is polled every interval instead.
+func WatchMCDeposits(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, client ChainReader,
+	store Store, scanner *LogScanner, confirmations uint64, interval time.Duration) {
+	keepWatching(ctx, "MCDeposit", interval, func() error {
+		return watchMCDeposits(ctx, sender, mc, sc, client, store, scanner, confirmations, interval)
+	})
+}
+
+func watchMCDeposits(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, client ChainReader,
+	store Store, scanner *LogScanner, confirmations uint64, interval time.Duration) error {
+	if _, err := CheckReorg(ctx, client, store, "MCDeposit"); err != nil {
+		return err
+	}
+	start, err := ResumeBlock(store, "MCDeposit")
+	if err != nil {
+		return err
+	}
+	poll := func() error {
+		return pollHeads(ctx, client, store, "MCDeposit", confirmations, interval, start, func(from, to uint64) error {
+			return ProcessMCDeposits(ctx, sender, mc, sc, store, scanner, from, &to)
+		})
+	}
+	// Confirmations come with new blocks rather than new events, so follow the head instead
+	if confirmations > 0 {
+		return poll()
+	}
+	sink := make(chan *mainchain.MainChainDeposit)
+	sub, err := mc.WatchDeposit(&bind.WatchOpts{Context: ctx}, sink, []common.Address{}, []common.Address{})
+	if err == rpc.ErrNotificationsUnsupported {
+		return poll()
+	}
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	// Catch up to the current head, anything newer comes from the subscription
+	head, err := headNumber(ctx, client)
+	if err != nil {
+		return err
+	}
+	if err := ProcessMCDeposits(ctx, sender, mc, sc, store, scanner, start, &head); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case event := <-sink:
+			if event.Raw.Removed {
+				return errLogRemoved
+			}
+			if event.Raw.BlockNumber > head {
+				if _, err := relayMCDeposit(ctx, sender, sc, store, event); err != nil {
+					return err
+				}
+				if err := PersistCheckpoint(store, "MCDeposit", logCheckpoint(event.Raw)); err != nil {
+					return err
+				}
+			}
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WatchSCDeposits processes the side chain deposits from the last checkpoint, then subscribes
+// to new deposits and signs them as they arrive until ctx is cancelled.
+// If events need confirmations, or if the endpoint doesn't support subscriptions,
+// the chain head is polled every interval instead.
+func WatchSCDeposits(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, client ChainReader, mcClient HeadReader,
+	addr common.Address, key *ecdsa.PrivateKey,
+	store Store, scanner *LogScanner, confirmations uint64, interval time.Duration) {
+	keepWatching(ctx, "SCDeposit", interval, func() error {
+		return watchSCDeposits(ctx, sender, mc, sc, client, mcClient, addr, key, store, scanner, confirmations, interval)
+	})
+}
+
+func watchSCDeposits(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, client ChainReader, mcClient HeadReader,
+	addr common.Address, key *ecdsa.PrivateKey,
+	store Store, scanner *LogScanner, confirmations uint64, interval time.Duration) error {
+	if _, err := CheckReorg(ctx, client, store, "SCDeposit"); err != nil {
+		return err
+	}
+	start, err := ResumeBlock(store, "SCDeposit")
+	if err != nil {
+		return err
+	}
+	poll := func() error {
+		return pollHeads(ctx, client, store, "SCDeposit", confirmations, interval, start, func(from, to uint64) error {
+			return ProcessSCDeposits(ctx, sender, mc, sc, mcClient, addr, key, store, scanner, from, &to)
+		})
+	}
+	if confirmations > 0 {
+		return poll()
+	}
+	sink := make(chan *sidechain.SideChainDeposit)
+	sub, err := sc.WatchDeposit(&bind.WatchOpts{Context: ctx}, sink, []common.Address{}, []common.Address{})
+	if err == rpc.ErrNotificationsUnsupported {
+		return poll()
+	}
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	head, err := headNumber(ctx, client)
+	if err != nil {
+		return err
+	}
+	if err := ProcessSCDeposits(ctx, sender, mc, sc, mcClient, addr, key, store, scanner, start, &head); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case event := <-sink:
+			if event.Raw.Removed {
+				return errLogRemoved
+			}
+			if event.Raw.BlockNumber > head {
+				if _, err := relaySCDeposit(ctx, sender, mc, sc, mcClient, addr, key, store, scanner, event); err != nil {
+					return err
+				}
+				if err := PersistCheckpoint(store, "SCDeposit", logCheckpoint(event.Raw)); err != nil {
+					return err
+				}
+			}
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WatchSCSignatureAdded processes the side chain signatures from the last checkpoint, then subscribes
+// to new signatures and submits the withdrawals that are ready until ctx is cancelled.
+// If events need confirmations, or if the endpoint doesn't support subscriptions,
+// the chain head is polled every interval instead.
+func WatchSCSignatureAdded(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, client ChainReader, mcClient HeadReader, addr common.Address,
+	store Store, scanner *LogScanner, fallbackTimeout time.Duration, confirmations uint64, interval time.Duration) {
+	keepWatching(ctx, "SCSignatureAdded", interval, func() error {
+		return watchSCSignatureAdded(ctx, sender, mc, sc, client, mcClient, addr, store, scanner, fallbackTimeout, confirmations, interval)
+	})
+}
+
+func watchSCSignatureAdded(ctx context.Context, sender *Sender,
+	mc MainChainBackend, sc SideChainBackend, client ChainReader, mcClient HeadReader, addr common.Address,
+	store Store, scanner *LogScanner, fallbackTimeout time.Duration, confirmations uint64, interval time.Duration) error {
+	if _, err := CheckReorg(ctx, client, store, "SCSignatureAdded"); err != nil {
+		return err
+	}
+	start, err := ResumeBlock(store, "SCSignatureAdded")
+	if err != nil {
+		return err
+	}
+	poll := func() error {
+		return pollHeads(ctx, client, store, "SCSignatureAdded", confirmations, interval, start, func(from, to uint64) error {
+			return ProcessSCSignatureAdded(ctx, sender, mc, sc, mcClient, addr, store, scanner, fallbackTimeout, from, &to)
+		})
+	}
+	if confirmations > 0 {
+		return poll()
+	}
+	sink := make(chan *sidechain.SideChainSignatureAdded)
+	sub, err := sc.WatchSignatureAdded(&bind.WatchOpts{Context: ctx}, sink)
+	if err == rpc.ErrNotificationsUnsupported {
+		return poll()
+	}
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	head, err := headNumber(ctx, client)
+	if err != nil {
+		return err
+	}
+	if err := ProcessSCSignatureAdded(ctx, sender, mc, sc, mcClient, addr, store, scanner, fallbackTimeout, start, &head); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case event := <-sink:
+			if event.Raw.Removed {
+				return errLogRemoved
+			}
+			if err := indexSignature(store, event); err != nil {
+				return err
+			}
+			if event.Raw.BlockNumber > head {
+				if _, err := relaySCSignatureAdded(ctx, sender, mc, sc, mcClient, addr, store, scanner, fallbackTimeout, event); err != nil {
+					return err
+				}
+				if err := PersistCheckpoint(store, "SCSignatureAdded", logCheckpoint(event.Raw)); err != nil {
+					return err
+				}
+			}
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// keepWatching calls watch again each time it stops, until ctx is cancelled
+func keepWatching(ctx context.Context, eventType string, interval time.Duration, watch func() error) {
+	for {
+		err := watch()
+		if ctx.Err() != nil {
+			return
+		}
+		componentLogger("watch").With(Fields{"event": eventType}).WithError(err).Warn("watch interrupted, restarting from the last checkpoint")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pollHeads calls process with each new range of confirmed blocks, checking the head every
+// interval, until ctx is cancelled. Before each range, the checkpoint of eventType is checked
+// for reorganizations and processing starts again from the rolled back checkpoint if needed.
+func pollHeads(ctx context.Context, client ChainReader, store Store, eventType string,
+	confirmations uint64, interval time.Duration, start uint64, process func(from, to uint64) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rolledBack, err := CheckReorg(ctx, client, store, eventType)
+		if err == nil && rolledBack {
+			start, err = ResumeBlock(store, eventType)
+		}
+		var head uint64
+		confirmed := false
+		if err == nil {
+			head, confirmed, err = ConfirmedBlock(ctx, client, confirmations)
+		}
+		if err == nil && confirmed && head >= start {
+			err = process(start, head)
+			if err == nil {
+				start = head + 1
+			}
+		}
+		if err != nil {
+			componentLogger("watch").With(Fields{"event": eventType}).WithError(err).Error("can't process the new blocks")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// headNumber returns the number of the latest block of the chain
+func headNumber(ctx context.Context, client HeadReader) (uint64, error) {
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, rpcError("get head", err)
+	}
+	return head.Number.Uint64(), nil
+}
+
+// ConfirmedBlock returns the number of the latest block that has at least the given number
+// of confirmations. A confirmation is a block mined on top of it. ok is false while the chain
+// is shorter than the confirmations, and no block is confirmed yet.
+func ConfirmedBlock(ctx context.Context, client HeadReader, confirmations uint64) (block uint64, ok bool, err error) {
+	head, err := headNumber(ctx, client)
+	if err != nil {
+		return 0, false, err
+	}
+	if head < confirmations {
+		return 0, false, nil
+	}
+	return head - confirmations, true, nil
+}
+
+// ConfirmedEndBlock caps end so that only blocks with enough confirmations are processed.
+// An open range is closed at the confirmed block, so that it can be read in windows.
+// ok is false when no block is confirmed yet, and there is nothing to process.
+func ConfirmedEndBlock(ctx context.Context, client HeadReader, end *uint64, confirmations uint64) (confirmedEnd *uint64, ok bool, err error) {
+	if confirmations == 0 && end != nil {
+		return end, true, nil
+	}
+	confirmed, ok, err := ConfirmedBlock(ctx, client, confirmations)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if end != nil && *end < confirmed {
+		return end, true, nil
+	}
+	return &confirmed, true, nil
+}
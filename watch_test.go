@@ -0,0 +1,157 @@
+/*
+Copyright (C) 2018 WeTrustPlatform
+
+This file is part of poa-interchain-node.
+
+poa-interchain-node is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+poa-interchain-node is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with poa-interchain-node.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package icn
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPollHeads(t *testing.T) {
+	dbPath, _ := ioutil.TempDir("", "icn")
+	defer os.RemoveAll(dbPath)
+	store, _ := NewFileStore(dbPath)
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &fakeChain{heads: []int64{10, 10, 12, 20}, cancel: cancel}
+
+	// The first range fails and is processed again on the next tick
+	var have [][2]uint64
+	err := pollHeads(ctx, client, store, "MCDeposit", 0, time.Millisecond, 5, func(from, to uint64) error {
+		have = append(have, [2]uint64{from, to})
+		if len(have) == 1 {
+			return rpcError("filter deposits", errors.New("connection refused"))
+		}
+		return nil
+	})
+
+	want := [][2]uint64{{5, 10}, {5, 10}, {11, 12}, {13, 20}}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have = %v, want %v", have, want)
+	}
+	if err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPollHeadsYoungChain(t *testing.T) {
+	dbPath, _ := ioutil.TempDir("", "icn")
+	defer os.RemoveAll(dbPath)
+	store, _ := NewFileStore(dbPath)
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &fakeChain{heads: []int64{2, 4, 7}, cancel: cancel}
+
+	// No block is processed, not even block 0, until the chain is deeper than the confirmations
+	var have [][2]uint64
+	pollHeads(ctx, client, store, "MCDeposit", 5, time.Millisecond, 0, func(from, to uint64) error {
+		have = append(have, [2]uint64{from, to})
+		return nil
+	})
+
+	want := [][2]uint64{{0, 2}}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have = %v, want %v", have, want)
+	}
+}
+
+func TestConfirmedEndBlock(t *testing.T) {
+	var five, nine, ten uint64 = 5, 9, 10
+	tests := []struct {
+		name          string
+		head          int64
+		end           *uint64
+		confirmations uint64
+		want          *uint64
+		wantOK        bool
+	}{
+		{
+			name:          "Returns end if no confirmations are required",
+			head:          10,
+			end:           &five,
+			confirmations: 0,
+			want:          &five,
+			wantOK:        true,
+		},
+		{
+			name:          "Closes an open range at the head",
+			head:          10,
+			end:           nil,
+			confirmations: 0,
+			want:          &ten,
+			wantOK:        true,
+		},
+		{
+			name:          "Caps an open range to the confirmed block",
+			head:          14,
+			end:           nil,
+			confirmations: 5,
+			want:          &nine,
+			wantOK:        true,
+		},
+		{
+			name:          "Caps end to the confirmed block",
+			head:          14,
+			end:           &nine,
+			confirmations: 9,
+			want:          &five,
+			wantOK:        true,
+		},
+		{
+			name:          "Keeps end if it is already confirmed",
+			head:          14,
+			end:           &five,
+			confirmations: 5,
+			want:          &five,
+			wantOK:        true,
+		},
+		{
+			name:          "Confirms nothing while the chain is shorter than the confirmations",
+			head:          4,
+			end:           nil,
+			confirmations: 5,
+			want:          nil,
+			wantOK:        false,
+		},
+		{
+			name:          "Confirms nothing for a closed range either",
+			head:          4,
+			end:           &five,
+			confirmations: 5,
+			want:          nil,
+			wantOK:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeChain{heads: []int64{tt.head}, cancel: func() {}}
+			got, ok, err := ConfirmedEndBlock(context.Background(), client, tt.end, tt.confirmations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || ok != tt.wantOK {
+				t.Errorf("ConfirmedEndBlock() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}